@@ -0,0 +1,128 @@
+package winfonts
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrBlocked is returned when Microsoft refuses to serve the request,
+	// as it does for addresses it has banned from the download connector.
+	ErrBlocked = errors.New("request blocked by Microsoft")
+	// ErrRateLimited is returned when Microsoft asks for requests to be
+	// sent less often.
+	ErrRateLimited = errors.New("rate limited by Microsoft")
+	// ErrNoSKU is returned when the product edition has no SKU to download.
+	ErrNoSKU = errors.New("no SKU found")
+	// ErrArchUnavailable is returned when a SKU has no download for the
+	// requested architecture.
+	ErrArchUnavailable = errors.New("architecture not available")
+)
+
+// sentinelRejectType is the type of the connector error reported when a
+// request is rejected.
+const sentinelRejectType = 9
+
+// ConnectorError is an error reported by the Microsoft download connector in
+// the Errors of a response.
+type ConnectorError struct {
+	Key   string `json:"Key"`
+	Value string `json:"Value"`
+	Type  int    `json:"Type"`
+}
+
+// APIError is a request to Microsoft that failed, either with an HTTP error
+// status or with errors in the response, which are kept as the server sent
+// them. Err is ErrBlocked or ErrRateLimited when the failure is one of those,
+// so that errors.Is can tell them apart.
+type APIError struct {
+	Err        error
+	Request    string
+	StatusCode int
+	Errors     []ConnectorError
+	// RetryAfter is how long the server asked to wait before trying
+	// again, or zero.
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	var b strings.Builder
+	b.WriteString(e.Request)
+	b.WriteString(" failed")
+	switch {
+	case e.Err != nil:
+		fmt.Fprintf(&b, ": %v", e.Err)
+	case e.StatusCode != http.StatusOK:
+		fmt.Fprintf(&b, " with status %d", e.StatusCode)
+	}
+	for _, ce := range e.Errors {
+		fmt.Fprintf(&b, "; %s", ce.Key)
+		if ce.Value != "" {
+			fmt.Fprintf(&b, ": %s", ce.Value)
+		}
+	}
+	return b.String()
+}
+
+func (e *APIError) Unwrap() error { return e.Err }
+
+// checkResponse returns an *APIError if resp, whose body is body, is a
+// failure of request. Errors are looked for both at the top level of the
+// response and in its ValidationContainer.
+func checkResponse(request string, resp *http.Response, body []byte) error {
+	var payload struct {
+		Errors              []ConnectorError `json:"Errors"`
+		ValidationContainer struct {
+			Errors []ConnectorError `json:"Errors"`
+		} `json:"ValidationContainer"`
+	}
+	// Bodies that are not JSON, such as the HTML of an error page, carry
+	// no connector errors.
+	json.Unmarshal(body, &payload)
+	errs := payload.Errors
+	if len(errs) == 0 {
+		errs = payload.ValidationContainer.Errors
+	}
+	if resp.StatusCode == http.StatusOK && len(errs) == 0 {
+		return nil
+	}
+
+	e := &APIError{
+		Request:    request,
+		StatusCode: resp.StatusCode,
+		Errors:     errs,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		e.Err = ErrRateLimited
+	case resp.StatusCode == http.StatusForbidden:
+		e.Err = ErrBlocked
+	}
+	for _, ce := range errs {
+		if ce.Type == sentinelRejectType || strings.Contains(ce.Key, "SentinelReject") {
+			e.Err = ErrBlocked
+		}
+	}
+	return e
+}
+
+// parseRetryAfter parses a Retry-After header, given either in seconds or as
+// a date. It returns zero if the header is missing or invalid.
+func parseRetryAfter(s string) time.Duration {
+	if s == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(s); err == nil {
+		return max(time.Duration(secs)*time.Second, 0)
+	}
+	if t, err := http.ParseTime(s); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
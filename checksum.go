@@ -0,0 +1,60 @@
+package winfonts
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+)
+
+// ChecksumError is returned when a downloaded ISO does not have the SHA-256
+// hash Microsoft publishes for it, which means it is truncated or corrupted.
+type ChecksumError struct {
+	File     string
+	Expected string
+	Actual   string
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("%s has SHA-256 %s, but Microsoft publishes %s", e.File, e.Actual, e.Expected)
+}
+
+// hashRowPattern matches a row of the "Verify your download" table of the
+// software download pages, such as
+// <td>English International 64-bit</td><td>A61A...E7B9</td>.
+var hashRowPattern = regexp.MustCompile(`(?is)<td[^>]*>\s*([^<]+?)\s*</td>\s*<td[^>]*>\s*([0-9a-f]{64})\s*</td>`)
+
+// parsePublishedHashes extracts the SHA-256 hashes listed on a software
+// download page, keyed by the lower-cased label of their row.
+func parsePublishedHashes(page []byte) map[string]string {
+	hashes := make(map[string]string)
+	for _, m := range hashRowPattern.FindAllSubmatch(page, -1) {
+		label := strings.ToLower(strings.Join(strings.Fields(html.UnescapeString(string(m[1]))), " "))
+		hashes[label] = strings.ToLower(string(m[2]))
+	}
+	return hashes
+}
+
+// hashLabel returns how the hash table names the ISO of a language, such as
+// "English International", for an architecture.
+func hashLabel(language string, arch Architecture) string {
+	suffix := "64-bit"
+	switch arch {
+	case ArchX86:
+		suffix = "32-bit"
+	case ArchARM64:
+		suffix = "Arm64"
+	}
+	return strings.ToLower(language + " " + suffix)
+}
+
+// ExpectedSHA256 returns the SHA-256 hash, in lower-case hex, that Microsoft
+// publishes for the ISO whose URL the last call to GetDownloadURL returned.
+// ok is false when the download page lists no hash for it.
+func (w *WindowsDownloader) ExpectedSHA256() (sum string, ok bool) {
+	if w.sku == nil {
+		return "", false
+	}
+	sum, ok = w.hashes[hashLabel(w.sku.Language, w.arch)]
+	return sum, ok
+}
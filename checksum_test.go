@@ -0,0 +1,46 @@
+package winfonts
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParsePublishedHashes(t *testing.T) {
+	page, err := os.ReadFile(filepath.Join("testdata", "hashes.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashes := parsePublishedHashes(page)
+	if len(hashes) != 15 {
+		t.Errorf("got %d hashes, want 15", len(hashes))
+	}
+	for _, tt := range []struct {
+		language string
+		arch     Architecture
+		label    string
+	}{
+		{"English", ArchX64, "English 64-bit"},
+		{"English", ArchX86, "English 32-bit"},
+		{"English International", ArchX64, "English International 64-bit"},
+		{"English International", ArchX86, "English International 32-bit"},
+		{"Chinese Simplified", ArchX64, "Chinese Simplified 64-bit"},
+		{"English", ArchARM64, "English Arm64"},
+		{"English International", ArchARM64, "English International Arm64"},
+	} {
+		w := NewWindowsDownloader(Windows10, EditionPro, tt.arch, LanguageEnglishUS)
+		w.sku = &SKUInfo{Language: tt.language}
+		w.hashes = hashes
+		sum, ok := w.ExpectedSHA256()
+		if want := sha256Hex([]byte(tt.label)); !ok || sum != want {
+			t.Errorf("%s %s: got %q, %v, want the hash of %q", tt.language, tt.arch, sum, ok, tt.label)
+		}
+	}
+
+	w := NewWindowsDownloader(Windows10, EditionPro, ArchARM64, LanguageEnglishUS)
+	w.sku = &SKUInfo{Language: "Arabic"}
+	w.hashes = hashes
+	if sum, ok := w.ExpectedSHA256(); ok {
+		t.Errorf("got %s for a language with no Arm64 hash", sum)
+	}
+}
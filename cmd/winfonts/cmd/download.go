@@ -2,9 +2,6 @@ package cmd
 
 import (
 	"fmt"
-	"io"
-	"net/http"
-	"os"
 
 	"github.com/actions-precompiled/winfonts"
 	"github.com/schollz/progressbar/v3"
@@ -18,6 +15,7 @@ var (
 	windowsLanguage  string
 	productEditionID string
 	outputFile       string
+	connections      int
 )
 
 var downloadCmd = &cobra.Command{
@@ -26,6 +24,9 @@ var downloadCmd = &cobra.Command{
 	Long: `Download Windows ISO files directly from Microsoft's official servers.
 This command uses the same API endpoints as Microsoft's official download tool.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, stop := signalContext(cmd)
+		defer stop()
+
 		version := winfonts.WindowsVersion(windowsVersion)
 		edition := winfonts.WindowsEdition(windowsEdition)
 		arch := winfonts.Architecture(windowsArch)
@@ -41,10 +42,13 @@ This command uses the same API endpoints as Microsoft's official download tool.`
 		fmt.Printf("  Architecture: %s\n", arch)
 		fmt.Printf("  Language: %s\n", language)
 
-		downloader := winfonts.NewWindowsDownloader(version, edition, arch, language)
+		downloader := winfonts.NewWindowsDownloader(version, edition, arch, language,
+			winfonts.WithConnections(connections),
+			winfonts.WithProgress(progressBar()),
+		)
 
 		fmt.Println("\nObtaining download URL from Microsoft...")
-		downloadURL, err := downloader.GetDownloadURL(productEditionID)
+		downloadURL, err := downloader.GetDownloadURL(ctx, productEditionID)
 		if err != nil {
 			return fmt.Errorf("failed to get download URL: %w", err)
 		}
@@ -56,7 +60,7 @@ This command uses the same API endpoints as Microsoft's official download tool.`
 		}
 
 		fmt.Printf("\nDownloading to: %s\n", outputFile)
-		if err := downloadFile(downloadURL, outputFile); err != nil {
+		if err := downloader.Download(ctx, downloadURL, outputFile); err != nil {
 			return fmt.Errorf("failed to download ISO: %w", err)
 		}
 
@@ -65,35 +69,16 @@ This command uses the same API endpoints as Microsoft's official download tool.`
 	},
 }
 
-func downloadFile(url, filepath string) error {
-	out, err := os.Create(filepath)
-	if err != nil {
-		return fmt.Errorf("failed to create output file: %w", err)
-	}
-	defer out.Close()
-
-	resp, err := http.Get(url)
-	if err != nil {
-		return fmt.Errorf("failed to download file: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("bad status: %s", resp.Status)
-	}
-
-	bar := progressbar.DefaultBytes(
-		resp.ContentLength,
-		"Downloading",
-	)
-
-	_, err = io.Copy(io.MultiWriter(out, bar), resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to write file: %w", err)
+// progressBar returns a WithProgress callback that shows the download on a
+// progress bar.
+func progressBar() func(done, total int64) {
+	var bar *progressbar.ProgressBar
+	return func(done, total int64) {
+		if bar == nil {
+			bar = progressbar.DefaultBytes(total, "Downloading")
+		}
+		bar.Set64(done)
 	}
-
-	fmt.Println()
-	return nil
 }
 
 func getDefaultProductEditionID(version winfonts.WindowsVersion, edition winfonts.WindowsEdition) string {
@@ -127,7 +112,8 @@ func init() {
 	downloadCmd.Flags().StringVarP(&windowsVersion, "version", "v", "windows11", "Windows version (windows11, windows10)")
 	downloadCmd.Flags().StringVarP(&windowsEdition, "edition", "e", "pro", "Windows edition (home, pro, enterprise, education)")
 	downloadCmd.Flags().StringVarP(&windowsArch, "arch", "a", "x64", "Architecture (x64, x86, ARM64)")
-	downloadCmd.Flags().StringVarP(&windowsLanguage, "language", "l", "en-US", "Language (en-US, pt-BR)")
+	downloadCmd.Flags().StringVarP(&windowsLanguage, "language", "l", "en-US", "Language, as a locale such as en-US or pt-BR, or as named by Microsoft, such as \"English International\"")
 	downloadCmd.Flags().StringVarP(&productEditionID, "product-id", "p", "", "Product edition ID (optional, uses defaults if not specified)")
 	downloadCmd.Flags().StringVarP(&outputFile, "output", "o", "", "Output file path (default: windows_{version}_{edition}_{arch}.iso)")
+	downloadCmd.Flags().IntVar(&connections, "connections", 4, "Number of concurrent connections to download with")
 }
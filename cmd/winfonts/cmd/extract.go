@@ -3,47 +3,100 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/actions-precompiled/winfonts"
 	"github.com/spf13/cobra"
 )
 
+var (
+	extractFormats     []string
+	extractSearchRoots []string
+	extractFullWalk    bool
+)
+
 var extractCmd = &cobra.Command{
-	Use:   "extract <iso-file> <output-directory>",
-	Short: "Extract fonts from a Windows ISO file",
+	Use:   "extract <iso-wim-or-directory> <output-directory>",
+	Short: "Extract fonts from a Windows ISO, WIM or ESD file, or a directory",
 	Long: `Extract fonts from a Windows ISO file to a specified output directory.
-The command will mount the ISO, locate the fonts directory, and extract all font files.`,
+The command will mount the ISO, locate the fonts directory, and extract all font files.
+
+A bare install.wim or install.esd file, identified by its extension, is read
+directly instead of being looked up inside an ISO. A directory, such as a
+mounted Windows partition or a copy of Windows/Fonts, is searched as is.`,
 	Args: cobra.ExactArgs(2),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		isoFile := args[0]
+		inputFile := args[0]
 		outputDir := args[1]
 
-		if _, err := os.Stat(isoFile); os.IsNotExist(err) {
-			return fmt.Errorf("ISO file does not exist: %s", isoFile)
+		formats, err := parseFormats(extractFormats)
+		if err != nil {
+			return err
+		}
+
+		info, err := os.Stat(inputFile)
+		if os.IsNotExist(err) {
+			return fmt.Errorf("input file does not exist: %s", inputFile)
+		}
+		if err != nil {
+			return err
 		}
 
 		if err := os.MkdirAll(outputDir, 0755); err != nil {
 			return fmt.Errorf("failed to create output directory: %w", err)
 		}
 
-		fmt.Printf("Extracting fonts from %s to %s\n", isoFile, outputDir)
+		fmt.Printf("Extracting fonts from %s to %s\n", inputFile, outputDir)
 
-		f, err := os.Open(isoFile)
-		if err != nil {
-			return fmt.Errorf("failed to open ISO file: %w", err)
+		opts := []winfonts.ExtractorOption{
+			winfonts.WithFormats(formats...),
+			winfonts.WithSearchRoots(extractSearchRoots...),
+			winfonts.WithFullWalk(extractFullWalk),
 		}
-		defer f.Close()
+		var extractor *winfonts.FontExtractor
+		if info.IsDir() {
+			extractor, err = winfonts.NewDirFontExtractor(inputFile, outputDir, opts...)
+		} else {
+			f, openErr := os.Open(inputFile)
+			if openErr != nil {
+				return fmt.Errorf("failed to open input file: %w", openErr)
+			}
+			defer f.Close()
 
-		extractor, err := winfonts.NewFontExtractor(f, outputDir)
+			switch strings.ToLower(filepath.Ext(inputFile)) {
+			case ".wim", ".esd":
+				extractor, err = winfonts.NewWimFontExtractor(f, inputFile, outputDir, opts...)
+			default:
+				extractor, err = winfonts.NewFontExtractor(f, outputDir, opts...)
+			}
+		}
 		if err != nil {
 			return fmt.Errorf("failed to create font extractor: %w", err)
 		}
 
-		return extractor.Run(cmd.Context())
-
+		ctx, stop := signalContext(cmd)
+		defer stop()
+		return extractor.Run(ctx)
 	},
 }
 
+func parseFormats(names []string) ([]winfonts.FontFormat, error) {
+	formats := make([]winfonts.FontFormat, 0, len(names))
+	for _, name := range names {
+		format, err := winfonts.ParseFontFormat(name)
+		if err != nil {
+			return nil, err
+		}
+		formats = append(formats, format)
+	}
+	return formats, nil
+}
+
 func init() {
 	rootCmd.AddCommand(extractCmd)
+
+	extractCmd.Flags().StringSliceVarP(&extractFormats, "formats", "f", []string{"ttf", "otf", "ttc", "fon"}, "Font formats to extract (ttf, otf, ttc, fon)")
+	extractCmd.Flags().StringSliceVar(&extractSearchRoots, "search-root", winfonts.DefaultSearchRoots, "Directories inside each WIM image to search for fonts")
+	extractCmd.Flags().BoolVar(&extractFullWalk, "full-walk", false, "Walk every directory of each WIM image instead of only the search roots (slow)")
 }
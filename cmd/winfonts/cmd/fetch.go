@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 
@@ -10,25 +11,66 @@ import (
 )
 
 var (
-	fetchVersion  string
-	fetchEdition  string
-	fetchArch     string
-	fetchLanguage string
-	fetchProductID string
-	fetchOutputDir string
-	keepISO       bool
+	fetchVersion     string
+	fetchEdition     string
+	fetchArch        string
+	fetchLanguage    string
+	fetchProductID   string
+	fetchOutput      string
+	fetchArchive     string
+	keepISO          bool
+	fetchFormats     []string
+	fetchRoots       []string
+	fetchFullWalk    bool
+	fetchStream      bool
+	fetchConnections int
 )
 
 var fetchCmd = &cobra.Command{
 	Use:   "fetch",
 	Short: "Download Windows ISO and extract fonts",
 	Long: `Download a Windows ISO from Microsoft's servers and automatically extract fonts.
-This command combines the download and extract operations into a single step.`,
+This command combines the download and extract operations into a single step.
+
+By default the fonts are written to the output directory. With --archive zip,
+tar.gz or tar.zst they are packed into an archive at the output path instead;
+an output of "-" writes the archive to standard output.
+
+With --stream the ISO is not downloaded: it is read with HTTP range requests,
+fetching only the parts that hold the WIM metadata and the fonts.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		if fetchOutputDir == "" {
-			return fmt.Errorf("output directory is required (use -o or --output)")
+		if fetchOutput == "" {
+			return fmt.Errorf("output is required (use -o or --output)")
+		}
+
+		formats, err := parseFormats(fetchFormats)
+		if err != nil {
+			return err
+		}
+
+		outputFormat := winfonts.OutputDir
+		if fetchArchive != "" {
+			outputFormat, err = winfonts.ParseOutputFormat(fetchArchive)
+			if err != nil {
+				return err
+			}
+		}
+		if fetchStream && keepISO {
+			return fmt.Errorf("--keep-iso cannot be used with --stream")
+		}
+		if fetchOutput == "-" && outputFormat == winfonts.OutputDir {
+			return fmt.Errorf("writing to standard output requires --archive")
 		}
 
+		// Keep standard output clean when the archive goes there.
+		status := os.Stdout
+		if fetchOutput == "-" {
+			status = os.Stderr
+		}
+
+		ctx, stop := signalContext(cmd)
+		defer stop()
+
 		version := winfonts.WindowsVersion(fetchVersion)
 		edition := winfonts.WindowsEdition(fetchEdition)
 		arch := winfonts.Architecture(fetchArch)
@@ -38,59 +80,97 @@ This command combines the download and extract operations into a single step.`,
 			fetchProductID = getDefaultProductEditionID(version, edition)
 		}
 
-		if err := os.MkdirAll(fetchOutputDir, 0755); err != nil {
+		// A kept ISO goes into the output directory, or next to the
+		// archive.
+		isoDir := fetchOutput
+		if outputFormat != winfonts.OutputDir {
+			isoDir = filepath.Dir(fetchOutput)
+		} else if err := os.MkdirAll(fetchOutput, 0755); err != nil {
 			return fmt.Errorf("failed to create output directory: %w", err)
 		}
 
 		tempISO := filepath.Join(os.TempDir(), fmt.Sprintf("winfonts_%s_%s_%s.iso", version, edition, arch))
 		if keepISO {
-			tempISO = filepath.Join(fetchOutputDir, fmt.Sprintf("windows_%s_%s_%s.iso", version, edition, arch))
+			tempISO = filepath.Join(isoDir, fmt.Sprintf("windows_%s_%s_%s.iso", version, edition, arch))
 		}
 
-		fmt.Printf("Fetching Windows fonts...\n")
-		fmt.Printf("  Version: %s\n", version)
-		fmt.Printf("  Edition: %s\n", edition)
-		fmt.Printf("  Architecture: %s\n", arch)
-		fmt.Printf("  Language: %s\n", language)
-		fmt.Printf("  Output: %s\n", fetchOutputDir)
+		fmt.Fprintf(status, "Fetching Windows fonts...\n")
+		fmt.Fprintf(status, "  Version: %s\n", version)
+		fmt.Fprintf(status, "  Edition: %s\n", edition)
+		fmt.Fprintf(status, "  Architecture: %s\n", arch)
+		fmt.Fprintf(status, "  Language: %s\n", language)
+		fmt.Fprintf(status, "  Output: %s (%s)\n", fetchOutput, outputFormat)
 
-		downloader := winfonts.NewWindowsDownloader(version, edition, arch, language)
+		downloader := winfonts.NewWindowsDownloader(version, edition, arch, language,
+			winfonts.WithConnections(fetchConnections),
+			winfonts.WithProgress(progressBar()),
+		)
 
-		fmt.Println("\nObtaining download URL from Microsoft...")
-		downloadURL, err := downloader.GetDownloadURL(fetchProductID)
+		fmt.Fprintln(status, "\nObtaining download URL from Microsoft...")
+		downloadURL, err := downloader.GetDownloadURL(ctx, fetchProductID)
 		if err != nil {
 			return fmt.Errorf("failed to get download URL: %w", err)
 		}
 
-		fmt.Printf("Download URL obtained\n")
-
-		fmt.Printf("\nDownloading ISO to: %s\n", tempISO)
-		if err := downloadFile(downloadURL, tempISO); err != nil {
-			return fmt.Errorf("failed to download ISO: %w", err)
+		fmt.Fprintf(status, "Download URL obtained\n")
+
+		var iso io.ReaderAt
+		var remote *winfonts.RangeReader
+		if fetchStream {
+			remote, err = winfonts.NewRangeReader(ctx, downloader.HTTPClient(), downloadURL)
+			if err != nil {
+				return fmt.Errorf("failed to open remote ISO: %w", err)
+			}
+			iso = remote
+			fmt.Fprintln(status, "\nExtracting fonts from remote ISO...")
+		} else {
+			fmt.Fprintf(status, "\nDownloading ISO to: %s\n", tempISO)
+			if err := downloader.Download(ctx, downloadURL, tempISO); err != nil {
+				return fmt.Errorf("failed to download ISO: %w", err)
+			}
+
+			fmt.Fprintln(status, "\nExtracting fonts from ISO...")
+			isoFile, err := os.Open(tempISO)
+			if err != nil {
+				return fmt.Errorf("failed to open ISO file: %w", err)
+			}
+			defer isoFile.Close()
+			iso = isoFile
 		}
 
-		fmt.Println("\nExtracting fonts from ISO...")
-		isoFile, err := os.Open(tempISO)
+		sink, err := winfonts.OpenSink(fetchOutput, outputFormat)
 		if err != nil {
-			return fmt.Errorf("failed to open ISO file: %w", err)
+			return err
 		}
-		defer isoFile.Close()
 
-		extractor, err := winfonts.NewFontExtractor(isoFile, fetchOutputDir)
-		if err != nil {
-			return fmt.Errorf("failed to create font extractor: %w", err)
+		extractor, err := winfonts.NewFontExtractor(iso, fetchOutput,
+			winfonts.WithFormats(formats...),
+			winfonts.WithSearchRoots(fetchRoots...),
+			winfonts.WithFullWalk(fetchFullWalk),
+			winfonts.WithSink(sink),
+		)
+		if err == nil {
+			err = extractor.Run(ctx)
 		}
-
-		if err := extractor.Run(cmd.Context()); err != nil {
+		if cerr := sink.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			// Do not leave a truncated archive behind.
+			if outputFormat != winfonts.OutputDir && fetchOutput != "-" {
+				os.Remove(fetchOutput)
+			}
 			return fmt.Errorf("failed to extract fonts: %w", err)
 		}
 
-		if !keepISO {
-			fmt.Printf("\nCleaning up temporary ISO file...\n")
+		if remote != nil {
+			fmt.Fprintf(status, "\nDownloaded %d MB of the %d MB ISO\n", remote.Fetched()>>20, remote.Size()>>20)
+		} else if !keepISO {
+			fmt.Fprintf(status, "\nCleaning up temporary ISO file...\n")
 			os.Remove(tempISO)
 		}
 
-		fmt.Printf("\nFonts successfully extracted to: %s\n", fetchOutputDir)
+		fmt.Fprintf(status, "\nFonts successfully extracted to: %s\n", fetchOutput)
 		return nil
 	},
 }
@@ -98,13 +178,24 @@ This command combines the download and extract operations into a single step.`,
 func init() {
 	rootCmd.AddCommand(fetchCmd)
 
-	fetchCmd.Flags().StringVarP(&fetchOutputDir, "output", "o", "", "Output directory for extracted fonts (required)")
+	fetchCmd.Flags().StringVarP(&fetchOutput, "output", "o", "", "Output directory, or archive file with --archive (required)")
+	fetchCmd.Flags().StringVar(&fetchArchive, "archive", "", "Pack the fonts into an archive of this format (zip, tar.gz, tar.zst) instead of a directory")
+	// --format was the name of --archive, and is too easily confused with
+	// --formats.
+	fetchCmd.Flags().StringVar(&fetchArchive, "format", "", "Output format (dir, zip, tar.gz, tar.zst)")
+	fetchCmd.Flags().MarkDeprecated("format", "use --archive instead")
 	fetchCmd.Flags().StringVarP(&fetchVersion, "version", "v", "windows11", "Windows version (windows11, windows10)")
 	fetchCmd.Flags().StringVarP(&fetchEdition, "edition", "e", "pro", "Windows edition (home, pro, enterprise, education)")
 	fetchCmd.Flags().StringVarP(&fetchArch, "arch", "a", "x64", "Architecture (x64, x86, ARM64)")
-	fetchCmd.Flags().StringVarP(&fetchLanguage, "language", "l", "en-US", "Language (en-US, pt-BR)")
+	fetchCmd.Flags().StringVarP(&fetchLanguage, "language", "l", "en-US", "Language, as a locale such as en-US or pt-BR, or as named by Microsoft, such as \"English International\"")
 	fetchCmd.Flags().StringVarP(&fetchProductID, "product-id", "p", "", "Product edition ID (optional)")
 	fetchCmd.Flags().BoolVarP(&keepISO, "keep-iso", "k", false, "Keep the downloaded ISO file after extraction")
+	fetchCmd.Flags().StringSliceVarP(&fetchFormats, "formats", "f", []string{"ttf", "otf", "ttc", "fon"}, "Font formats to extract (ttf, otf, ttc, fon)")
+	fetchCmd.Flags().StringSliceVar(&fetchRoots, "search-root", winfonts.DefaultSearchRoots, "Directories inside each WIM image to search for fonts")
+	fetchCmd.Flags().BoolVar(&fetchFullWalk, "full-walk", false, "Walk every directory of each WIM image instead of only the search roots (slow)")
+
+	fetchCmd.Flags().IntVar(&fetchConnections, "connections", 4, "Number of concurrent connections to download the ISO with")
+	fetchCmd.Flags().BoolVar(&fetchStream, "stream", false, "Read only the needed parts of the ISO with HTTP range requests instead of downloading it")
 
 	fetchCmd.MarkFlagRequired("output")
 }
@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/actions-precompiled/winfonts"
+	"github.com/spf13/cobra"
+)
+
+var (
+	listFormats     []string
+	listSearchRoots []string
+	listFullWalk    bool
+	listJSON        bool
+)
+
+var listCmd = &cobra.Command{
+	Use:   "list <iso-file>",
+	Short: "List the fonts contained in a Windows ISO file",
+	Long: `List every font file found in the WIM images of a Windows ISO file without
+extracting anything. Each entry shows the WIM file, the image index and name,
+the path inside the image, the uncompressed size and the SHA-1 hash the WIM
+stores for the file.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		isoFile := args[0]
+
+		formats, err := parseFormats(listFormats)
+		if err != nil {
+			return err
+		}
+
+		f, err := os.Open(isoFile)
+		if err != nil {
+			return fmt.Errorf("failed to open ISO file: %w", err)
+		}
+		defer f.Close()
+
+		extractor, err := winfonts.NewFontExtractor(f, "",
+			winfonts.WithFormats(formats...),
+			winfonts.WithSearchRoots(listSearchRoots...),
+			winfonts.WithFullWalk(listFullWalk),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to create font extractor: %w", err)
+		}
+
+		ctx, stop := signalContext(cmd)
+		defer stop()
+
+		candidates := []winfonts.FontCandidate{}
+		for c, err := range extractor.Candidates(ctx) {
+			if err != nil {
+				return fmt.Errorf("failed to list fonts: %w", err)
+			}
+			candidates = append(candidates, c)
+		}
+
+		if listJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(candidates)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "WIM\tINDEX\tIMAGE\tPATH\tSIZE\tSHA1")
+		for _, c := range candidates {
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%d\t%s\n", c.Wim, c.ImageIndex, c.ImageName, c.Path, c.Size, c.SHA1)
+		}
+		return w.Flush()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(listCmd)
+
+	listCmd.Flags().StringSliceVarP(&listFormats, "formats", "f", []string{"ttf", "otf", "ttc", "fon"}, "Font formats to list (ttf, otf, ttc, fon)")
+	listCmd.Flags().StringSliceVar(&listSearchRoots, "search-root", winfonts.DefaultSearchRoots, "Directories inside each WIM image to search for fonts")
+	listCmd.Flags().BoolVar(&listFullWalk, "full-walk", false, "Walk every directory of each WIM image instead of only the search roots (slow)")
+	listCmd.Flags().BoolVar(&listJSON, "json", false, "Print the fonts as JSON instead of a table")
+}
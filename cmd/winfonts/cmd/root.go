@@ -1,8 +1,11 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/spf13/cobra"
 )
@@ -24,6 +27,12 @@ func Execute() {
 	}
 }
 
+// signalContext returns a context derived from the command's that is cancelled
+// on SIGINT or SIGTERM, so that long-running work can stop and clean up.
+func signalContext(cmd *cobra.Command) (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+}
+
 func init() {
 	rootCmd.Flags().BoolP("version", "v", false, "Print version information")
 }
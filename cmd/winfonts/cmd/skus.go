@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/actions-precompiled/winfonts"
+	"github.com/spf13/cobra"
+)
+
+var (
+	skusVersion   string
+	skusEdition   string
+	skusLanguage  string
+	skusProductID string
+	skusJSON      bool
+	skusArchs     bool
+	skusDelay     time.Duration
+
+	// skusOptions are added to the options of the downloader, so that
+	// tests can send its requests to a stub server.
+	skusOptions []winfonts.DownloaderOption
+)
+
+// skuListing is a SKU as printed by the skus command.
+type skuListing struct {
+	ID                string                  `json:"id"`
+	Language          string                  `json:"language"`
+	LocalizedLanguage string                  `json:"localized_language"`
+	Architectures     []winfonts.Architecture `json:"architectures,omitempty"`
+	Expires           string                  `json:"expires,omitempty"`
+}
+
+var skusCmd = &cobra.Command{
+	Use:   "skus",
+	Short: "List the languages and architectures Microsoft offers a Windows ISO in",
+	Long: `List every SKU Microsoft offers for a product edition. Each SKU is a language
+of the ISO. With --architectures, the entries also show the architectures it
+can be downloaded for and when the download links Microsoft hands out for it
+expire. That takes a request per SKU, which are spaced out by --delay, as
+Microsoft blocks clients that send many requests in a burst.
+
+The languages listed are the values --language accepts in the download and
+fetch commands, besides locales such as en-US.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, stop := signalContext(cmd)
+		defer stop()
+
+		version := winfonts.WindowsVersion(skusVersion)
+		edition := winfonts.WindowsEdition(skusEdition)
+		if skusProductID == "" {
+			skusProductID = getDefaultProductEditionID(version, edition)
+		}
+
+		downloader := winfonts.NewWindowsDownloader(version, edition, winfonts.ArchX64, winfonts.Language(skusLanguage), skusOptions...)
+		skus, err := downloader.SKUs(ctx, skusProductID)
+		if err != nil {
+			return err
+		}
+
+		listings := []skuListing{}
+		for i, sku := range skus {
+			listing := skuListing{
+				ID:                sku.ID,
+				Language:          sku.Language,
+				LocalizedLanguage: sku.LocalizedLanguage,
+			}
+			if skusArchs {
+				if i > 0 {
+					select {
+					case <-time.After(skusDelay):
+					case <-ctx.Done():
+						return ctx.Err()
+					}
+				}
+				options, err := downloader.DownloadOptions(ctx, sku.ID)
+				if err != nil {
+					return fmt.Errorf("failed to get downloads of %s: %w", sku.Language, err)
+				}
+				listing.Expires = options.DownloadExpirationDatetime
+				for _, option := range options.ProductDownloadOptions {
+					if arch := option.Architecture(); arch != "" {
+						listing.Architectures = append(listing.Architectures, arch)
+					}
+				}
+			}
+			listings = append(listings, listing)
+		}
+
+		if skusJSON {
+			enc := json.NewEncoder(cmd.OutOrStdout())
+			enc.SetIndent("", "  ")
+			return enc.Encode(listings)
+		}
+
+		w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+		if !skusArchs {
+			fmt.Fprintln(w, "SKU\tLANGUAGE\tLOCALIZED")
+			for _, l := range listings {
+				fmt.Fprintf(w, "%s\t%s\t%s\n", l.ID, l.Language, l.LocalizedLanguage)
+			}
+			return w.Flush()
+		}
+		fmt.Fprintln(w, "SKU\tLANGUAGE\tLOCALIZED\tARCHITECTURES\tEXPIRES")
+		for _, l := range listings {
+			archs := make([]string, len(l.Architectures))
+			for i, arch := range l.Architectures {
+				archs[i] = string(arch)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", l.ID, l.Language, l.LocalizedLanguage, strings.Join(archs, ","), l.Expires)
+		}
+		return w.Flush()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(skusCmd)
+
+	skusCmd.Flags().StringVarP(&skusVersion, "version", "v", "windows11", "Windows version (windows11, windows10)")
+	skusCmd.Flags().StringVarP(&skusEdition, "edition", "e", "pro", "Windows edition (home, pro, enterprise, education)")
+	skusCmd.Flags().StringVarP(&skusLanguage, "language", "l", "en-US", "Locale to name the languages in")
+	skusCmd.Flags().StringVarP(&skusProductID, "product-id", "p", "", "Product edition ID (optional, uses defaults if not specified)")
+	skusCmd.Flags().BoolVar(&skusJSON, "json", false, "Print the SKUs as JSON instead of a table")
+	skusCmd.Flags().BoolVarP(&skusArchs, "architectures", "a", false, "Also list the architectures and link expiry of each SKU, at the cost of a request per SKU")
+	skusCmd.Flags().DurationVar(&skusDelay, "delay", 2*time.Second, "Pause between the per-SKU requests of --architectures")
+}
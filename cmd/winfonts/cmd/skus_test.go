@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/actions-precompiled/winfonts"
+)
+
+// stubTransport sends every request to the stub server at target, whatever
+// host it is addressed to.
+type stubTransport struct {
+	target *url.URL
+}
+
+func (t stubTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = t.target.Scheme
+	req.URL.Host = t.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// connectorStub stands in for the Microsoft download page and connector,
+// offering three SKUs, and counts the requests made to each of them.
+func connectorStub(t *testing.T) map[string]int {
+	var m sync.Mutex
+	requests := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var step string
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/en-US/software-download/"):
+			step = "page"
+		case r.URL.Path == "/tags":
+			step = "session"
+		case strings.HasSuffix(r.URL.Path, "/getskuinformationbyproductedition"):
+			step = "sku"
+			fmt.Fprint(w, `{"Skus":[`+
+				`{"Id":"1","Language":"English","LocalizedLanguage":"English (United States)"},`+
+				`{"Id":"2","Language":"French","LocalizedLanguage":"Français"},`+
+				`{"Id":"3","Language":"German","LocalizedLanguage":"Deutsch"}]}`)
+		case strings.HasSuffix(r.URL.Path, "/GetProductDownloadLinksBySku"):
+			step = "link"
+			fmt.Fprint(w, `{"DownloadExpirationDatetime":"2026-10-17T12:00:00Z","ProductDownloadOptions":[`+
+				`{"DownloadType":1,"Uri":"https://example.com/x64.iso"},`+
+				`{"DownloadType":2,"Uri":"https://example.com/arm64.iso"}]}`)
+		default:
+			http.NotFound(w, r)
+		}
+		m.Lock()
+		requests[step]++
+		m.Unlock()
+	}))
+	t.Cleanup(srv.Close)
+
+	target, _ := url.Parse(srv.URL)
+	skusOptions = []winfonts.DownloaderOption{winfonts.WithTransport(stubTransport{target})}
+	t.Cleanup(func() { skusOptions = nil })
+	return requests
+}
+
+func runSkus(t *testing.T, args ...string) []skuListing {
+	t.Helper()
+	var out bytes.Buffer
+	rootCmd.SetOut(&out)
+	rootCmd.SetArgs(append([]string{"skus", "--json"}, args...))
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	var listings []skuListing
+	if err := json.Unmarshal(out.Bytes(), &listings); err != nil {
+		t.Fatalf("%v in output %q", err, out.String())
+	}
+	return listings
+}
+
+func TestSkusMakesOneConnectorRequest(t *testing.T) {
+	requests := connectorStub(t)
+	listings := runSkus(t, "--architectures=false")
+	if len(listings) != 3 || listings[1].Language != "French" || listings[1].Architectures != nil {
+		t.Errorf("got listings %+v", listings)
+	}
+	if requests["sku"] != 1 || requests["link"] != 0 {
+		t.Errorf("made %d SKU and %d download link requests, want 1 and 0", requests["sku"], requests["link"])
+	}
+}
+
+func TestSkusArchitectures(t *testing.T) {
+	requests := connectorStub(t)
+	start := time.Now()
+	listings := runSkus(t, "--architectures", "--delay", "20ms")
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("listed 3 SKUs in %s, want at least 2 delays of 20ms", elapsed)
+	}
+	if len(listings) != 3 {
+		t.Fatalf("got %d listings, want 3", len(listings))
+	}
+	for _, l := range listings {
+		if len(l.Architectures) != 2 || l.Architectures[1] != winfonts.ArchARM64 || l.Expires == "" {
+			t.Errorf("got listing %+v", l)
+		}
+	}
+	if requests["sku"] != 1 || requests["link"] != 3 {
+		t.Errorf("made %d SKU and %d download link requests, want 1 and 3", requests["sku"], requests["link"])
+	}
+}
@@ -0,0 +1,102 @@
+package winfonts
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// FontOrigin records one place in the source media where a font was found.
+// The WIM fields are empty for fonts that do not come from a WIM image.
+type FontOrigin struct {
+	Wim        string `json:"wim,omitempty"`
+	ImageIndex int    `json:"image_index,omitempty"`
+	ImageName  string `json:"image_name,omitempty"`
+	Path       string `json:"path"`
+}
+
+func (o FontOrigin) String() string {
+	if o.Wim == "" {
+		return o.Path
+	}
+	return fmt.Sprintf("%s image %d at %s", o.Wim, o.ImageIndex, o.Path)
+}
+
+// ExtractedFont describes a unique font file written to the output directory.
+// Identical content found in several images or WIM files is stored once and
+// every location it was seen at is listed in Origins.
+type ExtractedFont struct {
+	Name    string       `json:"path"`
+	Format  FontFormat   `json:"format"`
+	SHA256  string       `json:"sha256"`
+	Size    int64        `json:"size"`
+	Faces   []FontInfo   `json:"faces,omitempty"`
+	Origins []FontOrigin `json:"origins"`
+}
+
+// NameConflict reports a file name that was found with more than one distinct
+// content. Each version is saved under its own name, listed in Fonts.
+type NameConflict struct {
+	Name  string
+	Fonts []*ExtractedFont
+}
+
+// fontIndex keeps track of the fonts saved during a run.
+type fontIndex struct {
+	fonts    []*ExtractedFont
+	bySHA256 map[string]*ExtractedFont
+	byWimSHA map[string]*ExtractedFont
+	byName   map[string][]*ExtractedFont
+}
+
+func newFontIndex() *fontIndex {
+	return &fontIndex{
+		bySHA256: make(map[string]*ExtractedFont),
+		byWimSHA: make(map[string]*ExtractedFont),
+		byName:   make(map[string][]*ExtractedFont),
+	}
+}
+
+// nameKey folds file names the way Windows compares them, so ARIAL.TTF and
+// arial.ttf are treated as the same name.
+func nameKey(name string) string {
+	return strings.ToLower(name)
+}
+
+// outputName picks the output file name for new content with the given
+// SHA-256. The first version of a name keeps it unchanged; later versions with
+// different content get a hash suffix so they do not overwrite each other.
+func (idx *fontIndex) outputName(name, sum string) string {
+	if len(idx.byName[nameKey(name)]) == 0 {
+		return name
+	}
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + sum[:8] + ext
+}
+
+func (idx *fontIndex) add(original string, font *ExtractedFont, wimHash string) {
+	idx.fonts = append(idx.fonts, font)
+	idx.bySHA256[font.SHA256] = font
+	if wimHash != "" {
+		idx.byWimSHA[wimHash] = font
+	}
+	key := nameKey(original)
+	idx.byName[key] = append(idx.byName[key], font)
+}
+
+func (idx *fontIndex) conflicts() []NameConflict {
+	var conflicts []NameConflict
+	for _, fonts := range idx.byName {
+		if len(fonts) > 1 {
+			conflicts = append(conflicts, NameConflict{
+				Name:  fonts[0].Name,
+				Fonts: fonts,
+			})
+		}
+	}
+	sort.Slice(conflicts, func(i, j int) bool {
+		return nameKey(conflicts[i].Name) < nameKey(conflicts[j].Name)
+	})
+	return conflicts
+}
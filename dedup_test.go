@@ -0,0 +1,150 @@
+package winfonts
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"iter"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+)
+
+// memSource is a Source yielding fixed candidates.
+type memSource []FontCandidate
+
+func (s memSource) Candidates(ctx context.Context, opts SearchOptions) iter.Seq2[FontCandidate, error] {
+	return func(yield func(FontCandidate, error) bool) {
+		for _, c := range s {
+			if !yield(c, nil) {
+				return
+			}
+		}
+	}
+}
+
+// memCandidate returns a candidate at p in wim image index with the given
+// content, like those of a WIM source when wim is set.
+func memCandidate(wim string, index int, p string, data []byte) FontCandidate {
+	return FontCandidate{
+		Name:       path.Base(p),
+		Path:       p,
+		Wim:        wim,
+		ImageIndex: index,
+		Size:       int64(len(data)),
+		Open: func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(data)), nil
+		},
+	}
+}
+
+// runExtractor extracts the fonts of src into a new directory, which it
+// returns along with the extractor.
+func runExtractor(t *testing.T, src Source, opts ...ExtractorOption) (*FontExtractor, string) {
+	t.Helper()
+	output := t.TempDir()
+	e := NewSourceExtractor(output, []Source{src}, opts...)
+	if err := e.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return e, output
+}
+
+func checkOutputFile(t *testing.T, output, name string, want []byte) {
+	t.Helper()
+	got, err := os.ReadFile(filepath.Join(output, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s: content differs", name)
+	}
+}
+
+func TestDedupIdenticalFonts(t *testing.T) {
+	font := testFont(1)
+	opened := memCandidate("sources/install.wim", 2, "Windows/Fonts/arial.ttf", font)
+	opened.SHA1 = "aaaa"
+	sameSHA1 := memCandidate("sources/install.wim", 3, "Windows/Fonts/arial.ttf", nil)
+	sameSHA1.SHA1 = "aaaa"
+	sameSHA1.Open = func() (io.ReadCloser, error) {
+		t.Error("a candidate with an already saved SHA1 was opened")
+		return nil, errors.New("unexpected open")
+	}
+	e, output := runExtractor(t, memSource{
+		opened,
+		sameSHA1,
+		memCandidate("", 0, "extra/ARIAL.TTF", font),
+		memCandidate("sources/boot.wim", 1, "Windows/Fonts/copy.ttf", font),
+	})
+
+	fonts := e.Fonts()
+	if len(fonts) != 1 {
+		t.Fatalf("got %d fonts, want 1", len(fonts))
+	}
+	if fonts[0].Name != "arial.ttf" || fonts[0].SHA256 != sha256Hex(font) {
+		t.Errorf("got font %s sha256:%s, want arial.ttf sha256:%s", fonts[0].Name, fonts[0].SHA256, sha256Hex(font))
+	}
+	want := []FontOrigin{
+		{Wim: "sources/install.wim", ImageIndex: 2, Path: "Windows/Fonts/arial.ttf"},
+		{Wim: "sources/install.wim", ImageIndex: 3, Path: "Windows/Fonts/arial.ttf"},
+		{Path: "extra/ARIAL.TTF"},
+		{Wim: "sources/boot.wim", ImageIndex: 1, Path: "Windows/Fonts/copy.ttf"},
+	}
+	if len(fonts[0].Origins) != len(want) {
+		t.Fatalf("got origins %v, want %v", fonts[0].Origins, want)
+	}
+	for i, origin := range fonts[0].Origins {
+		if origin != want[i] {
+			t.Errorf("origin %d is %v, want %v", i, origin, want[i])
+		}
+	}
+	if conflicts := e.Conflicts(); len(conflicts) != 0 {
+		t.Errorf("got conflicts %v for identical fonts", conflicts)
+	}
+	checkOutputFile(t, output, "arial.ttf", font)
+	if _, err := os.Stat(filepath.Join(output, "copy.ttf")); !os.IsNotExist(err) {
+		t.Errorf("a duplicate font was saved again: %v", err)
+	}
+}
+
+func TestDedupNameCollision(t *testing.T) {
+	first, second, third := testFont(1), testFont(2), testFont(3)
+	e, output := runExtractor(t, memSource{
+		memCandidate("sources/install.wim", 1, "Windows/Fonts/arial.ttf", first),
+		memCandidate("sources/install.wim", 2, "Windows/Fonts/ARIAL.TTF", second),
+		memCandidate("sources/install.wim", 3, "Windows/Fonts/arial.ttf", third),
+		memCandidate("sources/install.wim", 4, "Windows/Fonts/arial.ttf", second),
+	})
+
+	names := []string{
+		"arial.ttf",
+		"ARIAL." + sha256Hex(second)[:8] + ".TTF",
+		"arial." + sha256Hex(third)[:8] + ".ttf",
+	}
+	fonts := e.Fonts()
+	if len(fonts) != len(names) {
+		t.Fatalf("got %d fonts, want %d", len(fonts), len(names))
+	}
+	for i, data := range [][]byte{first, second, third} {
+		if fonts[i].Name != names[i] {
+			t.Errorf("font %d saved as %s, want %s", i, fonts[i].Name, names[i])
+		}
+		checkOutputFile(t, output, names[i], data)
+	}
+	if n := len(fonts[1].Origins); n != 2 {
+		t.Errorf("second version has %d origins, want 2", n)
+	}
+
+	conflicts := e.Conflicts()
+	if len(conflicts) != 1 || conflicts[0].Name != "arial.ttf" || len(conflicts[0].Fonts) != 3 {
+		t.Fatalf("got conflicts %+v, want arial.ttf with 3 versions", conflicts)
+	}
+	for i, font := range conflicts[0].Fonts {
+		if font != fonts[i] {
+			t.Errorf("conflict lists %s as version %d, want %s", font.Name, i, fonts[i].Name)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package winfonts
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"iter"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// NewDirFontExtractor returns an extractor for fonts in a plain directory
+// tree, such as a mounted Windows partition, a copied Windows/Fonts directory
+// or an unpacked WIM image. The search roots are looked up relative to dir;
+// when none of them exist, dir itself is searched, so it can also point
+// straight at a fonts directory.
+func NewDirFontExtractor(dir, output string, opts ...ExtractorOption) (*FontExtractor, error) {
+	src, err := NewDirSource(dir)
+	if err != nil {
+		return nil, err
+	}
+	return newFontExtractor([]Source{src}, output, opts), nil
+}
+
+// NewDirSource returns a Source for the fonts in a plain directory tree. See
+// NewDirFontExtractor for how the search roots are looked up.
+func NewDirSource(dir string) (Source, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	return dirSource{dir}, nil
+}
+
+// dirSource finds fonts in a directory tree.
+type dirSource struct {
+	root string
+}
+
+func (s dirSource) Candidates(ctx context.Context, search SearchOptions) iter.Seq2[FontCandidate, error] {
+	return func(yield func(FontCandidate, error) bool) {
+		// The output directory may be inside the tree; the fonts
+		// written there must not be picked up again.
+		skip := make(map[string]bool, len(search.SkipDirs))
+		for _, dir := range search.SkipDirs {
+			if abs, err := filepath.Abs(dir); err == nil {
+				skip[abs] = true
+			}
+		}
+
+		stopped := false
+		walk := func(dir string) bool {
+			err := filepath.WalkDir(filepath.Join(s.root, filepath.FromSlash(dir)), func(p string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				if d.IsDir() {
+					if abs, _ := filepath.Abs(p); skip[abs] {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				if _, ok := formatForName(d.Name()); !ok {
+					return nil
+				}
+				// Follow symbolic links to fonts, which are common in
+				// font directories outside of Windows.
+				info, err := os.Stat(p)
+				if err != nil || !info.Mode().IsRegular() {
+					return nil
+				}
+				rel, err := filepath.Rel(s.root, p)
+				if err != nil {
+					return err
+				}
+				c := FontCandidate{
+					Name: d.Name(),
+					Path: filepath.ToSlash(rel),
+					Size: info.Size(),
+					Open: func() (io.ReadCloser, error) {
+						return os.Open(p)
+					},
+				}
+				if !yield(c, nil) {
+					stopped = true
+					return filepath.SkipAll
+				}
+				return nil
+			})
+			if err != nil {
+				yield(FontCandidate{}, err)
+				return false
+			}
+			return !stopped
+		}
+
+		if search.FullWalk {
+			walk("")
+			return
+		}
+
+		found := false
+		for _, searchRoot := range search.Roots {
+			dir, err := lookupDirPath(s.root, searchRoot)
+			if err != nil {
+				yield(FontCandidate{}, err)
+				return
+			}
+			if dir == "" {
+				log.Printf("  %s not found in %s", searchRoot, s.root)
+				continue
+			}
+			found = true
+			if !walk(dir) {
+				return
+			}
+		}
+		if !found {
+			log.Printf("  No search root found, searching %s itself", s.root)
+			walk("")
+		}
+	}
+}
+
+// lookupDirPath resolves name below root like lookupWimPath does in WIM
+// images, matching each component case-insensitively as Windows does. It
+// returns the slash-separated path of the directory relative to root, or ""
+// when it does not exist.
+func lookupDirPath(root, name string) (string, error) {
+	var dirPath string
+	for _, component := range strings.Split(strings.ReplaceAll(name, `\`, "/"), "/") {
+		if component == "" || component == "." {
+			continue
+		}
+		entries, err := os.ReadDir(filepath.Join(root, filepath.FromSlash(dirPath)))
+		if err != nil {
+			return "", fmt.Errorf("failed to read directory %s: %w", dirPath, err)
+		}
+		next := ""
+		for _, entry := range entries {
+			if entry.IsDir() && strings.EqualFold(entry.Name(), component) {
+				next = entry.Name()
+				break
+			}
+		}
+		if next == "" {
+			return "", nil
+		}
+		dirPath = path.Join(dirPath, next)
+	}
+	if dirPath == "" {
+		// name refers to root itself.
+		return ".", nil
+	}
+	return dirPath, nil
+}
@@ -1,14 +1,16 @@
 package winfonts
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
+	"time"
 
-	"github.com/davecgh/go-spew/spew"
 	"github.com/google/uuid"
 )
 
@@ -16,11 +18,28 @@ const (
 	orgID     = "y6jn8c31"
 	profileID = "606624d44113"
 
+	pageEndpoint     = "https://www.microsoft.com/en-US/software-download/"
 	sessionEndpoint  = "https://vlscppe.microsoft.com/tags"
 	skuEndpoint      = "https://www.microsoft.com/software-download-connector/api/getskuinformationbyproductedition"
 	downloadEndpoint = "https://www.microsoft.com/software-download-connector/api/GetProductDownloadLinksBySku"
 )
 
+// endpoints are the URLs of the steps of the download flow. The download page
+// URL is followed by the Windows version.
+type endpoints struct {
+	page     string
+	session  string
+	sku      string
+	download string
+}
+
+var defaultEndpoints = endpoints{
+	page:     pageEndpoint,
+	session:  sessionEndpoint,
+	sku:      skuEndpoint,
+	download: downloadEndpoint,
+}
+
 type WindowsVersion string
 
 const (
@@ -59,6 +78,133 @@ type WindowsDownloader struct {
 	edition   WindowsEdition
 	arch      Architecture
 	language  Language
+
+	connections int
+	progress    func(done, total int64)
+	userAgent   string
+	timeouts    Timeouts
+	retry       RetryPolicy
+	endpoints   endpoints
+
+	// sessionRegistered is false until sessionID has been registered, and
+	// again once Microsoft has rejected a request made with it.
+	sessionRegistered bool
+
+	// transport and proxy are applied to the client once every option
+	// has been read. timeoutsSet records whether WithTimeouts was given,
+	// as the default timeouts leave the caller's transport alone.
+	transport   http.RoundTripper
+	proxy       *url.URL
+	timeoutsSet bool
+
+	// hashes are the SHA-256 hashes published on the download page, and
+	// sku is the SKU the last download URL was obtained for, from the
+	// product edition productEditionID.
+	hashes           map[string]string
+	sku              *SKUInfo
+	productEditionID string
+}
+
+// DownloaderOption configures optional behaviour of a WindowsDownloader.
+type DownloaderOption func(*WindowsDownloader)
+
+// WithConnections sets how many concurrent connections Download uses. It
+// defaults to 4.
+func WithConnections(n int) DownloaderOption {
+	return func(w *WindowsDownloader) {
+		w.connections = max(n, 1)
+	}
+}
+
+// WithProgress makes Download call report as data arrives, with the number of
+// bytes of the file downloaded so far and its size, or -1 if the size is
+// unknown. Calls are not concurrent.
+func WithProgress(report func(done, total int64)) DownloaderOption {
+	return func(w *WindowsDownloader) {
+		w.progress = report
+	}
+}
+
+// defaultUserAgent is the browser the requests to the Microsoft API pretend
+// to come from.
+const defaultUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+
+// WithHTTPClient makes the downloader send its requests with client. The
+// client is copied; if it has no cookie jar, the copy gets one, as the
+// Microsoft session relies on cookies. Its transport is used as is, unless
+// WithProxy or WithTimeouts is also given, in which case they are applied to
+// a clone of it.
+func WithHTTPClient(client *http.Client) DownloaderOption {
+	return func(w *WindowsDownloader) {
+		c := *client
+		w.client = &c
+	}
+}
+
+// WithTransport sets the transport of the downloader's HTTP client, for
+// example to trust custom CA roots. Like that of WithHTTPClient, it is only
+// changed by WithProxy and WithTimeouts.
+func WithTransport(transport http.RoundTripper) DownloaderOption {
+	return func(w *WindowsDownloader) {
+		w.transport = transport
+	}
+}
+
+// WithUserAgent sets the User-Agent header sent to the Microsoft API, which
+// defaults to that of a desktop browser.
+func WithUserAgent(userAgent string) DownloaderOption {
+	return func(w *WindowsDownloader) {
+		w.userAgent = userAgent
+	}
+}
+
+// WithProxy sends every request through the given proxy instead of the one
+// named by the HTTPS_PROXY and HTTP_PROXY environment variables. It requires
+// the transport to be an *http.Transport, as the default one is.
+func WithProxy(proxy *url.URL) DownloaderOption {
+	return func(w *WindowsDownloader) {
+		w.proxy = proxy
+	}
+}
+
+// Timeouts bounds how long the downloader waits for the network. A zero
+// duration means no limit.
+type Timeouts struct {
+	// Connect bounds establishing a connection, including the TLS
+	// handshake.
+	Connect time.Duration
+	// ResponseHeader bounds waiting for the headers of a response once the
+	// request has been sent.
+	ResponseHeader time.Duration
+	// API bounds each request to the Microsoft API, including reading its
+	// response. It does not apply to downloading the ISO itself.
+	API time.Duration
+}
+
+// DefaultTimeouts are the timeouts used unless WithTimeouts is given. Their
+// Connect and ResponseHeader timeouts only apply to the transport the
+// downloader creates itself, not to one given with WithHTTPClient or
+// WithTransport.
+var DefaultTimeouts = Timeouts{
+	Connect:        30 * time.Second,
+	ResponseHeader: time.Minute,
+	API:            2 * time.Minute,
+}
+
+// WithTimeouts sets the timeouts of the downloader. Connect and ResponseHeader
+// require the transport to be an *http.Transport, as the default one is.
+func WithTimeouts(timeouts Timeouts) DownloaderOption {
+	return func(w *WindowsDownloader) {
+		w.timeouts = timeouts
+		w.timeoutsSet = true
+	}
+}
+
+// HTTPClient returns the client the downloader sends its requests with, so
+// that other requests for the ISO, such as those of a RangeReader, go through
+// the same transport and proxy.
+func (w *WindowsDownloader) HTTPClient() *http.Client {
+	return w.client
 }
 
 type SKUInfo struct {
@@ -95,15 +241,89 @@ type DownloadResponse struct {
 	DownloadExpirationDatetime  string                 `json:"DownloadExpirationDatetime"`
 }
 
-func NewWindowsDownloader(version WindowsVersion, edition WindowsEdition, arch Architecture, language Language) *WindowsDownloader {
-	jar, _ := cookiejar.New(nil)
-	return &WindowsDownloader{
-		client:    &http.Client{Jar: jar},
-		sessionID: uuid.New().String(),
-		version:   version,
-		edition:   edition,
-		arch:      arch,
-		language:  language,
+func NewWindowsDownloader(version WindowsVersion, edition WindowsEdition, arch Architecture, language Language, opts ...DownloaderOption) *WindowsDownloader {
+	w := &WindowsDownloader{
+		client:      &http.Client{},
+		sessionID:   uuid.New().String(),
+		version:     version,
+		edition:     edition,
+		arch:        arch,
+		language:    language,
+		connections: 4,
+		userAgent:   defaultUserAgent,
+		timeouts:    DefaultTimeouts,
+		retry:       DefaultRetryPolicy,
+		endpoints:   defaultEndpoints,
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	w.configureClient()
+	return w
+}
+
+// configureClient applies the transport, proxy and timeout options to the
+// client. A transport that is not an *http.Transport is used as is, and one
+// the caller supplied is only cloned to apply WithProxy or WithTimeouts.
+func (w *WindowsDownloader) configureClient() {
+	if w.client.Jar == nil {
+		jar, _ := cookiejar.New(nil)
+		w.client.Jar = jar
+	}
+	if w.transport != nil {
+		w.client.Transport = w.transport
+	}
+
+	rt := w.client.Transport
+	own := rt == nil
+	if own {
+		rt = http.DefaultTransport
+	}
+	applyTimeouts := own || w.timeoutsSet
+	if !applyTimeouts && w.proxy == nil {
+		return
+	}
+	t, ok := rt.(*http.Transport)
+	if !ok {
+		return
+	}
+	t = t.Clone()
+	if w.proxy != nil {
+		t.Proxy = http.ProxyURL(w.proxy)
+	}
+	if applyTimeouts {
+		if w.timeouts.Connect > 0 {
+			t.DialContext = (&net.Dialer{
+				Timeout:   w.timeouts.Connect,
+				KeepAlive: 30 * time.Second,
+			}).DialContext
+			t.TLSHandshakeTimeout = w.timeouts.Connect
+		}
+		t.ResponseHeaderTimeout = w.timeouts.ResponseHeader
+	}
+	w.client.Transport = t
+}
+
+// apiContext bounds a request to the Microsoft API by the API timeout.
+func (w *WindowsDownloader) apiContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if w.timeouts.API <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, w.timeouts.API)
+}
+
+// Architecture returns the architecture of the ISO the option downloads, or
+// an empty string if the download type is unknown.
+func (o DownloadOption) Architecture() Architecture {
+	switch o.DownloadType {
+	case 0:
+		return ArchX86
+	case 1:
+		return ArchX64
+	case 2:
+		return ArchARM64
+	default:
+		return ""
 	}
 }
 
@@ -121,9 +341,9 @@ func (w *WindowsDownloader) getArchDownloadType() int {
 }
 
 func (w *WindowsDownloader) addBrowserHeaders(req *http.Request) {
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36")
+	req.Header.Set("User-Agent", w.userAgent)
 	req.Header.Set("Accept", "application/json, text/plain, */*")
-	req.Header.Set("Accept-Language", "en-US,en;q=0.9")
+	req.Header.Set("Accept-Language", acceptLanguage(w.language))
 	req.Header.Set("DNT", "1")
 	req.Header.Set("Connection", "keep-alive")
 	req.Header.Set("Sec-Fetch-Dest", "empty")
@@ -131,10 +351,13 @@ func (w *WindowsDownloader) addBrowserHeaders(req *http.Request) {
 	req.Header.Set("Sec-Fetch-Site", "same-origin")
 }
 
-func (w *WindowsDownloader) validateLocale() error {
-	localeURL := fmt.Sprintf("https://www.microsoft.com/en-US/software-download/%s", w.version)
+func (w *WindowsDownloader) validateLocale(ctx context.Context) error {
+	ctx, cancel := w.apiContext(ctx)
+	defer cancel()
+
+	localeURL := w.endpoints.page + string(w.version)
 
-	req, err := http.NewRequest("GET", localeURL, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", localeURL, nil)
 	if err != nil {
 		return fmt.Errorf("failed to create locale request: %w", err)
 	}
@@ -147,17 +370,25 @@ func (w *WindowsDownloader) validateLocale() error {
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("locale validation failed with status: %d", resp.StatusCode)
+	page, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read download page: %w", err)
+	}
+	if err := checkResponse("locale validation", resp, page); err != nil {
+		return err
 	}
+	w.hashes = parsePublishedHashes(page)
 
 	return nil
 }
 
-func (w *WindowsDownloader) registerSession() error {
-	sessionURL := fmt.Sprintf("%s?org_id=%s&session_id=%s", sessionEndpoint, orgID, w.sessionID)
+func (w *WindowsDownloader) registerSession(ctx context.Context) error {
+	ctx, cancel := w.apiContext(ctx)
+	defer cancel()
+
+	sessionURL := fmt.Sprintf("%s?org_id=%s&session_id=%s", w.endpoints.session, orgID, w.sessionID)
 
-	req, err := http.NewRequest("GET", sessionURL, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", sessionURL, nil)
 	if err != nil {
 		return fmt.Errorf("failed to create session request: %w", err)
 	}
@@ -170,25 +401,33 @@ func (w *WindowsDownloader) registerSession() error {
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("session registration failed with status: %d", resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read session response: %w", err)
+	}
+	if err := checkResponse("session registration", resp, body); err != nil {
+		return err
 	}
+	w.sessionRegistered = true
 
 	return nil
 }
 
-func (w *WindowsDownloader) getSKUInformation(productEditionID string) ([]SKUInfo, error) {
+func (w *WindowsDownloader) getSKUInformation(ctx context.Context, productEditionID string) ([]SKUInfo, error) {
+	ctx, cancel := w.apiContext(ctx)
+	defer cancel()
+
 	params := url.Values{}
 	params.Add("profile", profileID)
 	params.Add("productEditionId", productEditionID)
 	params.Add("SKU", "undefined")
 	params.Add("friendlyFileName", "undefined")
-	params.Add("Locale", string(w.language))
+	params.Add("Locale", string(locale(w.language)))
 	params.Add("sessionID", w.sessionID)
 
-	skuURL := fmt.Sprintf("%s?%s", skuEndpoint, params.Encode())
+	skuURL := fmt.Sprintf("%s?%s", w.endpoints.sku, params.Encode())
 
-	req, err := http.NewRequest("GET", skuURL, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", skuURL, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create SKU request: %w", err)
 	}
@@ -201,42 +440,39 @@ func (w *WindowsDownloader) getSKUInformation(productEditionID string) ([]SKUInf
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("SKU request failed with status: %d", resp.StatusCode)
-	}
-
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read SKU response: %w", err)
 	}
+	if err := checkResponse("SKU request", resp, body); err != nil {
+		return nil, err
+	}
 
 	var skuResponse SKUResponse
 	if err := json.Unmarshal(body, &skuResponse); err != nil {
-		var data interface{}
-		if err := json.Unmarshal(body, &data); err != nil {
-			return nil, fmt.Errorf("failed to unmarshal JSON: %w", err)
-		}
-		spew.Dump(data)
 		return nil, fmt.Errorf("failed to parse SKU response: %w", err)
 	}
 
 	return skuResponse.Skus, nil
 }
 
-func (w *WindowsDownloader) getDownloadLink(skuID string) (string, error) {
+func (w *WindowsDownloader) getDownloadOptions(ctx context.Context, skuID string) (*DownloadResponse, error) {
+	ctx, cancel := w.apiContext(ctx)
+	defer cancel()
+
 	params := url.Values{}
 	params.Add("profile", profileID)
 	params.Add("productEditionId", "undefined")
 	params.Add("SKU", skuID)
 	params.Add("friendlyFileName", "undefined")
-	params.Add("Locale", string(w.language))
+	params.Add("Locale", string(locale(w.language)))
 	params.Add("sessionID", w.sessionID)
 
-	downloadURL := fmt.Sprintf("%s?%s", downloadEndpoint, params.Encode())
+	downloadURL := fmt.Sprintf("%s?%s", w.endpoints.download, params.Encode())
 
-	req, err := http.NewRequest("GET", downloadURL, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", downloadURL, nil)
 	if err != nil {
-		return "", fmt.Errorf("failed to create download request: %w", err)
+		return nil, fmt.Errorf("failed to create download request: %w", err)
 	}
 
 	w.addBrowserHeaders(req)
@@ -244,61 +480,111 @@ func (w *WindowsDownloader) getDownloadLink(skuID string) (string, error) {
 
 	resp, err := w.client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to get download link: %w", err)
+		return nil, fmt.Errorf("failed to get download link: %w", err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("download link request failed with status: %d", resp.StatusCode)
-	}
-
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("failed to read download response: %w", err)
+		return nil, fmt.Errorf("failed to read download response: %w", err)
+	}
+	if err := checkResponse("download link request", resp, body); err != nil {
+		return nil, err
 	}
 
 	var downloadResp DownloadResponse
 	if err := json.Unmarshal(body, &downloadResp); err != nil {
-		var data interface{}
-		if err := json.Unmarshal(body, &data); err != nil {
-			return "", fmt.Errorf("failed to unmarshal JSON: %w", err)
-		}
-		spew.Dump(data)
-		return "", fmt.Errorf("failed to parse download response: %w", err)
+		return nil, fmt.Errorf("failed to parse download response: %w", err)
 	}
 
-	archType := w.getArchDownloadType()
-	for _, option := range downloadResp.ProductDownloadOptions {
-		if option.DownloadType == archType {
-			return option.Uri, nil
-		}
-	}
-
-	return "", fmt.Errorf("no download link found for architecture %s (download type %d)", w.arch, archType)
+	return &downloadResp, nil
 }
 
-func (w *WindowsDownloader) GetDownloadURL(productEditionID string) (string, error) {
-	if err := w.validateLocale(); err != nil {
-		return "", fmt.Errorf("failed to validate locale: %w", err)
+// SKUs returns every SKU Microsoft offers for the given product edition, one
+// per language. Each request is retried according to the retry policy.
+func (w *WindowsDownloader) SKUs(ctx context.Context, productEditionID string) ([]SKUInfo, error) {
+	err := w.withRetries(ctx, func() error {
+		return w.validateLocale(ctx)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to validate locale: %w", err)
 	}
 
-	if err := w.registerSession(); err != nil {
-		return "", fmt.Errorf("failed to register session: %w", err)
+	err = w.withRetries(ctx, func() error {
+		return w.ensureSession(ctx)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to register session: %w", err)
 	}
 
-	skus, err := w.getSKUInformation(productEditionID)
+	var skus []SKUInfo
+	err = w.withRetries(ctx, func() error {
+		if err := w.ensureSession(ctx); err != nil {
+			return err
+		}
+		var err error
+		skus, err = w.getSKUInformation(ctx, productEditionID)
+		return err
+	})
 	if err != nil {
-		return "", fmt.Errorf("failed to get SKU information: %w", err)
+		return nil, fmt.Errorf("failed to get SKU information: %w", err)
+	}
+	return skus, nil
+}
+
+// DownloadOptions returns the downloads Microsoft offers for a SKU, one per
+// architecture, along with when their links expire. SKUs must have been called
+// first to set up the session. The request is retried according to the retry
+// policy.
+func (w *WindowsDownloader) DownloadOptions(ctx context.Context, skuID string) (*DownloadResponse, error) {
+	var options *DownloadResponse
+	err := w.withRetries(ctx, func() error {
+		if err := w.ensureSession(ctx); err != nil {
+			return err
+		}
+		var err error
+		options, err = w.getDownloadOptions(ctx, skuID)
+		return err
+	})
+	return options, err
+}
+
+// GetDownloadURL obtains a download URL for the ISO of the given product
+// edition in the downloader's language. Microsoft signs the URL and only
+// keeps it valid for a day. Each request is retried according to the retry
+// policy.
+func (w *WindowsDownloader) GetDownloadURL(ctx context.Context, productEditionID string) (string, error) {
+	skus, err := w.SKUs(ctx, productEditionID)
+	if err != nil {
+		return "", err
 	}
 
 	if len(skus) == 0 {
-		return "", fmt.Errorf("no SKUs found for edition")
+		return "", fmt.Errorf("%w for product edition %s", ErrNoSKU, productEditionID)
+	}
+	sku, err := selectSKU(skus, w.language)
+	if err != nil {
+		return "", err
 	}
 
-	downloadURL, err := w.getDownloadLink(skus[0].ID)
+	options, err := w.DownloadOptions(ctx, sku.ID)
 	if err != nil {
 		return "", fmt.Errorf("failed to get download link: %w", err)
 	}
 
+	archType := w.getArchDownloadType()
+	var downloadURL string
+	for _, option := range options.ProductDownloadOptions {
+		if option.DownloadType == archType {
+			downloadURL = option.Uri
+			break
+		}
+	}
+	if downloadURL == "" {
+		return "", fmt.Errorf("%w: no download link found for architecture %s (download type %d)", ErrArchUnavailable, w.arch, archType)
+	}
+	w.sku = sku
+	w.productEditionID = productEditionID
+
 	return downloadURL, nil
 }
@@ -0,0 +1,96 @@
+package winfonts
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// callerTransport returns a transport with its own dialer and response header
+// timeout, and a function reporting whether the dialer has been used.
+func callerTransport() (*http.Transport, func() bool) {
+	dialed := false
+	t := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dialed = true
+			return nil, errors.New("no network")
+		},
+		ResponseHeaderTimeout: 5 * time.Second,
+	}
+	return t, func() bool { return dialed }
+}
+
+// checkCallerTransport checks that rt has the dialer and response header
+// timeout of callerTransport.
+func checkCallerTransport(t *testing.T, rt http.RoundTripper, dialed func() bool) {
+	t.Helper()
+	tr, ok := rt.(*http.Transport)
+	if !ok {
+		t.Fatalf("client has transport %T", rt)
+	}
+	if tr.ResponseHeaderTimeout != 5*time.Second {
+		t.Errorf("ResponseHeaderTimeout is %s, want 5s", tr.ResponseHeaderTimeout)
+	}
+	tr.DialContext(context.Background(), "tcp", "example.com:443")
+	if !dialed() {
+		t.Error("the caller's DialContext was replaced")
+	}
+}
+
+func TestCallerTransportKept(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		option func(*http.Transport) DownloaderOption
+	}{
+		{"WithTransport", func(tr *http.Transport) DownloaderOption { return WithTransport(tr) }},
+		{"WithHTTPClient", func(tr *http.Transport) DownloaderOption {
+			return WithHTTPClient(&http.Client{Transport: tr})
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tr, dialed := callerTransport()
+			w := NewWindowsDownloader(Windows11, EditionPro, ArchX64, LanguageEnglishUS, tt.option(tr))
+			if w.HTTPClient().Transport != tr {
+				t.Error("the caller's transport was replaced")
+			}
+			checkCallerTransport(t, w.HTTPClient().Transport, dialed)
+		})
+
+		t.Run(tt.name+" with a proxy", func(t *testing.T) {
+			tr, dialed := callerTransport()
+			proxy, _ := url.Parse("http://proxy.example.com:3128")
+			w := NewWindowsDownloader(Windows11, EditionPro, ArchX64, LanguageEnglishUS, tt.option(tr), WithProxy(proxy))
+			rt := w.HTTPClient().Transport
+			if rt == tr || tr.Proxy != nil {
+				t.Error("the proxy was set on the caller's transport")
+			}
+			checkCallerTransport(t, rt, dialed)
+		})
+	}
+}
+
+func TestTimeoutsApplied(t *testing.T) {
+	w := NewWindowsDownloader(Windows11, EditionPro, ArchX64, LanguageEnglishUS)
+	tr, ok := w.HTTPClient().Transport.(*http.Transport)
+	if !ok || tr == http.DefaultTransport {
+		t.Fatalf("client has transport %T, want a clone of the default one", w.HTTPClient().Transport)
+	}
+	if tr.ResponseHeaderTimeout != DefaultTimeouts.ResponseHeader || tr.TLSHandshakeTimeout != DefaultTimeouts.Connect {
+		t.Errorf("got timeouts %s and %s, want the default ones", tr.ResponseHeaderTimeout, tr.TLSHandshakeTimeout)
+	}
+
+	caller, _ := callerTransport()
+	timeouts := Timeouts{Connect: time.Second, ResponseHeader: 2 * time.Second}
+	w = NewWindowsDownloader(Windows11, EditionPro, ArchX64, LanguageEnglishUS, WithTransport(caller), WithTimeouts(timeouts))
+	tr = w.HTTPClient().Transport.(*http.Transport)
+	if tr == caller || caller.ResponseHeaderTimeout != 5*time.Second {
+		t.Error("WithTimeouts changed the caller's transport")
+	}
+	if tr.ResponseHeaderTimeout != timeouts.ResponseHeader || tr.TLSHandshakeTimeout != timeouts.Connect {
+		t.Errorf("got timeouts %s and %s, want those given", tr.ResponseHeaderTimeout, tr.TLSHandshakeTimeout)
+	}
+}
@@ -2,166 +2,327 @@ package winfonts
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
-	"iter"
 	"log"
 	"os"
-	"path/filepath"
-
-	"github.com/Microsoft/go-winio/wim"
-	"github.com/Xmister/udf"
 )
 
 type FontExtractor struct {
-	iso    *udf.Udf
-	output string
+	sources []Source
+	output  string
+	sink    Sink
+	formats map[FontFormat]bool
+	roots   []string
+	walkAll bool
+	index   *fontIndex
+}
+
+// ExtractorOption configures optional behaviour of a FontExtractor.
+type ExtractorOption func(*FontExtractor)
+
+// WithFormats restricts extraction to the given font formats. By default every
+// format in DefaultFormats is extracted.
+func WithFormats(formats ...FontFormat) ExtractorOption {
+	return func(e *FontExtractor) {
+		e.formats = make(map[FontFormat]bool, len(formats))
+		for _, format := range formats {
+			e.formats[format] = true
+		}
+	}
 }
 
-func NewFontExtractor(ra io.ReaderAt, output string) (*FontExtractor, error) {
-	iso, err := udf.NewUdfFromReader(ra)
+// DefaultSearchRoots are the directories looked up in each WIM image when no
+// search roots are configured.
+var DefaultSearchRoots = []string{"Windows/Fonts"}
+
+// WithSearchRoots sets the directories, relative to the image root, that are
+// searched for fonts in each WIM image. Both slash and backslash separators are
+// accepted and names are matched case-insensitively.
+func WithSearchRoots(roots ...string) ExtractorOption {
+	return func(e *FontExtractor) {
+		e.roots = roots
+	}
+}
+
+// WithFullWalk makes the extractor walk every directory of each WIM image
+// instead of only the search roots. This is much slower, but finds fonts
+// installed outside of the usual locations.
+func WithFullWalk(enabled bool) ExtractorOption {
+	return func(e *FontExtractor) {
+		e.walkAll = enabled
+	}
+}
+
+// WithSink makes the extractor store the fonts and the manifest in s instead of
+// the output directory. The caller closes s once Run returns.
+func WithSink(s Sink) ExtractorOption {
+	return func(e *FontExtractor) {
+		e.sink = s
+	}
+}
+
+func NewFontExtractor(ra io.ReaderAt, output string, opts ...ExtractorOption) (*FontExtractor, error) {
+	src, err := NewISOSource(ra)
 	if err != nil {
 		return nil, err
 	}
-	return &FontExtractor{
-		iso:    iso,
-		output: output,
-	}, nil
+	return newFontExtractor([]Source{src}, output, opts), nil
 }
 
-func (e *FontExtractor) saveReader(ctx context.Context, r io.Reader, outputFile string) error {
-	log.Printf("  Extracting font: %s", outputFile)
-	location := filepath.Join(e.output, outputFile)
-	f, err := os.Create(location)
+// NewWimFontExtractor returns an extractor for a bare WIM or ESD file that is
+// not wrapped in an ISO. name is the file name of the WIM, which is recorded
+// in the origins of the fonts and must have a .wim or .esd extension.
+func NewWimFontExtractor(ra io.ReaderAt, name, output string, opts ...ExtractorOption) (*FontExtractor, error) {
+	src, err := NewWimSource(ra, name)
 	if err != nil {
-		return fmt.Errorf("failed to create file %s: %w", outputFile, err)
+		return nil, err
+	}
+	return newFontExtractor([]Source{src}, output, opts), nil
+}
+
+func newFontExtractor(sources []Source, output string, opts []ExtractorOption) *FontExtractor {
+	e := &FontExtractor{
+		sources: sources,
+		output:  output,
+		roots:   DefaultSearchRoots,
+	}
+	WithFormats(DefaultFormats...)(e)
+	for _, opt := range opts {
+		opt(e)
 	}
+	return e
+}
+
+// fontFormat returns the format of the named file if it is one the extractor
+// has been configured to extract.
+func (e *FontExtractor) fontFormat(name string) (FontFormat, bool) {
+	format, ok := formatForName(name)
+	if !ok || !e.formats[format] {
+		return "", false
+	}
+	return format, true
+}
+
+// saveReader copies r into a temporary file while hashing it, returning the temporary path together with the content's
+// SHA-256 and size.
+func (e *FontExtractor) saveReader(ctx context.Context, r io.Reader) (string, string, int64, error) {
+	f, err := os.CreateTemp("", ".winfonts-*")
+	if err != nil {
+		return "", "", 0, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer f.Close()
+	h := sha256.New()
 	buf := make([]byte, 64*1024)
-	_, err = io.CopyBuffer(f, r, buf)
+	size, err := io.CopyBuffer(io.MultiWriter(f, h), &ctxReader{ctx, r}, buf)
+	if err == nil {
+		err = f.Close()
+	}
 	if err != nil {
-		return fmt.Errorf("failed to copy data to file %s: %w", outputFile, err)
+		os.Remove(f.Name())
+		return "", "", 0, fmt.Errorf("failed to copy data to file %s: %w", f.Name(), err)
 	}
-	return nil
+	return f.Name(), hex.EncodeToString(h.Sum(nil)), size, nil
+}
+
+// ctxReader stops reading once its context is done, so that copying a large
+// file can be interrupted.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
 }
 
-func (e *FontExtractor) wimFiles(image *wim.Image) iter.Seq2[*wim.File, error] {
-	return func(yield func(*wim.File, error) bool) {
-		var walk func(*wim.File) bool
+func (r *ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
 
-		walk = func(dir *wim.File) bool {
-			entries, err := dir.Readdir()
-			if err != nil {
-				yield(nil, fmt.Errorf("failed to read directory: %w", err))
-				return false
-			}
+func readFontInfoFile(name string) ([]FontInfo, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadFontInfo(f)
+}
 
-			for _, entry := range entries {
+// checkMagic checks that the file name, saved at tmp with the given size,
+// starts with the magic bytes of format, so that stray files which merely
+// carry a font extension are skipped.
+func checkMagic(tmp, name string, size int64, format FontFormat) error {
+	f, err := os.Open(tmp)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	hdr := make([]byte, min(size, magicPeekSize))
+	if _, err := io.ReadFull(f, hdr); err != nil {
+		return fmt.Errorf("failed to read header of %s: %w", name, err)
+	}
+	if n := format.magicLen(hdr, size); n > len(hdr) {
+		more := make([]byte, n-len(hdr))
+		if _, err := io.ReadFull(f, more); err != nil {
+			return fmt.Errorf("failed to read header of %s: %w", name, err)
+		}
+		hdr = append(hdr, more...)
+	}
+	if !format.matchMagic(hdr, size) {
+		return fmt.Errorf("%s does not look like a %s font", name, format)
+	}
+	return nil
+}
 
-				if !yield(entry, nil) {
-					return false
-				}
+// saveFont saves the content read from r if it looks like a font of format.
+// Its size is measured while copying it, so the size a source lists for it is
+// not relied upon. Content that was already saved from another location is
+// not written again; the new location is only recorded as an additional
+// origin.
+func (e *FontExtractor) saveFont(ctx context.Context, r io.Reader, name string, format FontFormat, origin FontOrigin, wimHash string) error {
+	tmp, sum, size, err := e.saveReader(ctx, r)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	if err := checkMagic(tmp, name, size, format); err != nil {
+		return err
+	}
 
-				if entry.IsDir() {
-					if !walk(entry) {
-						return false
-					}
-				}
-			}
-			return true
+	if font, ok := e.index.bySHA256[sum]; ok {
+		font.Origins = append(font.Origins, origin)
+		if wimHash != "" {
+			e.index.byWimSHA[wimHash] = font
 		}
+		return nil
+	}
 
-		root, err := image.Open()
+	var faces []FontInfo
+	if format != FormatBitmap {
+		faces, err = readFontInfoFile(tmp)
 		if err != nil {
-			yield(nil, fmt.Errorf("failed to open WIM image: %w", err))
-			return
+			log.Printf("failed to read metadata of font %s: %v", name, err)
 		}
+	}
 
-		walk(root)
+	outputName := e.index.outputName(name, sum)
+	log.Printf("  Extracting font: %s", outputName)
+	f, err := os.Open(tmp)
+	if err != nil {
+		return err
 	}
+	err = e.sink.WriteFile(outputName, f, size)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("failed to save font %s: %w", outputName, err)
+	}
+	e.index.add(name, &ExtractedFont{
+		Name:    outputName,
+		Format:  format,
+		SHA256:  sum,
+		Size:    size,
+		Faces:   faces,
+		Origins: []FontOrigin{origin},
+	}, wimHash)
+	return nil
 }
 
-func (e *FontExtractor) handleWimImage(ctx context.Context, image *wim.Image) error {
-	for file, err := range e.wimFiles(image) {
+func (e *FontExtractor) extractFonts(ctx context.Context) error {
+	e.index = newFontIndex()
+	log.Printf("Starting font extraction")
+	log.Printf("Scanning for fonts...")
+	for c, err := range e.Candidates(ctx) {
 		if err != nil {
 			return err
 		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 
-		log.Printf("wimfile: %s", file.Name)
-		ext := filepath.Ext(file.Name)
-		if ext == ".ttf" {
-			r, err := file.Open()
-			if err != nil {
-				log.Printf("failed to open font file %s in WIM image: %v", file.Name, err)
-				continue
-			}
-			err = e.saveReader(ctx, r, file.Name)
-			if err != nil {
-				log.Printf("failed to save font %s: %v", file.Name, err)
-				continue
-			}
+		origin := FontOrigin{
+			Wim:        c.Wim,
+			ImageIndex: c.ImageIndex,
+			ImageName:  c.ImageName,
+			Path:       c.Path,
+		}
+		if font, ok := e.index.byWimSHA[c.SHA1]; ok && c.SHA1 != "" {
+			font.Origins = append(font.Origins, origin)
+			continue
+		}
+		r, err := c.Open()
+		if err != nil {
+			log.Printf("failed to open font file %s: %v", c.Path, err)
+			continue
+		}
+		err = e.saveFont(ctx, r, c.Name, c.Format, origin, c.SHA1)
+		r.Close()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			log.Printf("failed to save font %s: %v", c.Name, err)
+			continue
 		}
 	}
+	e.reportFonts()
+	log.Printf("Font extraction completed successfully")
 	return nil
 }
 
-func (e *FontExtractor) handleWim(ctx context.Context, f udf.File) error {
-	wimName := f.Name()
-	log.Printf("Processing WIM file: %s", wimName)
-	r := f.NewReader()
-	bundle, err := wim.NewReader(r)
-	if err != nil {
-		return fmt.Errorf("failed to read WIM file %s: %w", wimName, err)
+// reportFonts logs a summary of the unique fonts saved and of every file name
+// that was seen with more than one distinct content.
+func (e *FontExtractor) reportFonts() {
+	occurrences := 0
+	for _, font := range e.index.fonts {
+		occurrences += len(font.Origins)
 	}
-	log.Printf("  Found %d image(s) in WIM", len(bundle.Image))
-	for idx, image := range bundle.Image {
-		log.Printf("wimimage: %s", image.Name)
-		log.Printf("  Processing image %d/%d", idx+1, len(bundle.Image))
-		err = e.handleWimImage(ctx, image)
-		if err != nil {
-			return fmt.Errorf("failed to process image in WIM file %s: %w", wimName, err)
+	log.Printf("Saved %d unique font(s) out of %d occurrence(s)", len(e.index.fonts), occurrences)
+	for _, conflict := range e.index.conflicts() {
+		log.Printf("Name conflict: %s has %d distinct versions", conflict.Name, len(conflict.Fonts))
+		for _, font := range conflict.Fonts {
+			log.Printf("  %s sha256:%s (first seen at %s)", font.Name, font.SHA256, font.Origins[0])
 		}
 	}
-	return nil
 }
 
-func (e *FontExtractor) isoFiles(yield func(udf.File) bool) {
-	var walk func([]udf.File) bool
-
-	walk = func(files []udf.File) bool {
-		for _, item := range files {
-			if !yield(item) {
-				return false
-			}
-
-			if item.IsDir() {
-				children := e.iso.ReadDir(item.FileEntry())
-				if !walk(children) {
-					return false
-				}
-			}
-		}
-		return true
+// Fonts returns the unique fonts saved by the last call to Run, in the order
+// they were first encountered.
+func (e *FontExtractor) Fonts() []*ExtractedFont {
+	if e.index == nil {
+		return nil
 	}
-
-	walk(e.iso.ReadDir(nil))
+	return e.index.fonts
 }
 
-func (e *FontExtractor) extractFonts(ctx context.Context) error {
-	log.Printf("Starting font extraction from ISO")
-	log.Printf("Scanning ISO for WIM files...")
-	for item := range e.isoFiles {
-		log.Printf("isofile: %s %s", item.Name(), filepath.Ext(item.Name()))
-		if filepath.Ext(item.Name()) == ".wim" {
-			err := e.handleWim(ctx, item)
-			if err != nil {
-				return fmt.Errorf("failed to extract fonts from %s: %w", item.Name(), err)
-			}
-		}
+// Conflicts returns the file names that the last call to Run found with more
+// than one distinct content.
+func (e *FontExtractor) Conflicts() []NameConflict {
+	if e.index == nil {
+		return nil
 	}
-	log.Printf("Font extraction completed successfully")
-	return nil
+	return e.index.conflicts()
 }
 
+// Run extracts the fonts and writes the manifest, into the sink set with
+// WithSink or else into the output directory. If ctx is cancelled, Run stops
+// after at most the file being copied, removes its partial output and returns
+// ctx.Err(); fonts saved before that are left in place, but no manifest is
+// written.
 func (e *FontExtractor) Run(ctx context.Context) error {
-	return e.extractFonts(ctx)
+	if e.sink == nil {
+		sink, err := NewDirSink(e.output)
+		if err != nil {
+			return err
+		}
+		e.sink = sink
+	}
+	if err := e.extractFonts(ctx); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	return e.writeManifest()
 }
@@ -0,0 +1,68 @@
+package winfonts
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"slices"
+	"testing"
+)
+
+// cancelReader returns the first half of data, then cancels its context
+// while more is still to be read.
+type cancelReader struct {
+	data   []byte
+	cancel context.CancelFunc
+	read   bool
+}
+
+func (r *cancelReader) Read(p []byte) (int, error) {
+	if r.read {
+		return copy(p, r.data[len(r.data)/2:]), io.EOF
+	}
+	r.read = true
+	r.cancel()
+	return copy(p, r.data[:len(r.data)/2]), nil
+}
+
+func dirNames(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func TestRunCancelled(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	interrupted := memCandidate("", 0, "fonts/times.ttf", nil)
+	interrupted.Open = func() (io.ReadCloser, error) {
+		return io.NopCloser(&cancelReader{data: testFont(2), cancel: cancel}), nil
+	}
+	src := memSource{
+		memCandidate("", 0, "fonts/arial.ttf", testFont(1)),
+		interrupted,
+		memCandidate("", 0, "fonts/cour.ttf", testFont(3)),
+	}
+	output := t.TempDir()
+	e := NewSourceExtractor(output, []Source{src})
+	if err := e.Run(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want context.Canceled", err)
+	}
+	if got := dirNames(t, output); !slices.Equal(got, []string{"arial.ttf"}) {
+		t.Errorf("output holds %q, want only the font saved before cancelling", got)
+	}
+	if got := dirNames(t, tmp); len(got) != 0 {
+		t.Errorf("temporary files %q left behind", got)
+	}
+}
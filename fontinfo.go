@@ -0,0 +1,257 @@
+package winfonts
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"unicode/utf16"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// FsType holds the OS/2 fsType embedding licensing bits of a font.
+type FsType uint16
+
+const (
+	FsTypeRestricted   FsType = 0x0002
+	FsTypePreviewPrint FsType = 0x0004
+	FsTypeEditable     FsType = 0x0008
+	FsTypeNoSubsetting FsType = 0x0100
+	FsTypeBitmapOnly   FsType = 0x0200
+)
+
+// Embedding names the embedding permission level encoded in the low bits of
+// fsType: "installable", "restricted", "preview-print" or "editable". When
+// several bits are set the least restrictive one wins, as the OpenType
+// specification requires.
+func (t FsType) Embedding() string {
+	switch {
+	case t&0x000f == 0:
+		return "installable"
+	case t&FsTypeEditable != 0:
+		return "editable"
+	case t&FsTypePreviewPrint != 0:
+		return "preview-print"
+	default:
+		return "restricted"
+	}
+}
+
+// FontInfo is the metadata of a single font face, read from its OpenType
+// name, head and OS/2 tables.
+type FontInfo struct {
+	Family               string  `json:"family"`
+	Subfamily            string  `json:"subfamily"`
+	TypographicFamily    string  `json:"typographic_family,omitempty"`
+	TypographicSubfamily string  `json:"typographic_subfamily,omitempty"`
+	FullName             string  `json:"full_name"`
+	PostScriptName       string  `json:"postscript_name"`
+	Version              string  `json:"version"`
+	FontRevision         float64 `json:"font_revision"`
+	VendorID             string  `json:"vendor_id,omitempty"`
+	FsType               FsType  `json:"fs_type"`
+	Embedding            string  `json:"embedding"`
+}
+
+// ErrNotSfnt is returned by ReadFontInfo for data that is neither an sfnt
+// font nor a TrueType Collection.
+var ErrNotSfnt = errors.New("not an sfnt font")
+
+// name table IDs read into FontInfo.
+const (
+	nameFamily               = 1
+	nameSubfamily            = 2
+	nameFullName             = 4
+	nameVersion              = 5
+	namePostScript           = 6
+	nameTypographicFamily    = 16
+	nameTypographicSubfamily = 17
+)
+
+// ReadFontInfo parses the font in r and returns the metadata of each face it
+// contains: one for a plain TrueType or OpenType font, one per member for a
+// TrueType Collection.
+func ReadFontInfo(r io.ReaderAt) ([]FontInfo, error) {
+	var hdr [12]byte
+	if _, err := r.ReadAt(hdr[:], 0); err != nil {
+		return nil, fmt.Errorf("failed to read font header: %w", err)
+	}
+
+	if string(hdr[:4]) != "ttcf" {
+		if !isSfntMagic(hdr[:]) {
+			return nil, ErrNotSfnt
+		}
+		info, err := readFace(r, 0)
+		if err != nil {
+			return nil, err
+		}
+		return []FontInfo{info}, nil
+	}
+
+	numFonts := binary.BigEndian.Uint32(hdr[8:])
+	if numFonts > 0xffff {
+		return nil, fmt.Errorf("implausible collection size %d", numFonts)
+	}
+	offsets := make([]byte, 4*numFonts)
+	if _, err := r.ReadAt(offsets, 12); err != nil {
+		return nil, fmt.Errorf("failed to read collection header: %w", err)
+	}
+	faces := make([]FontInfo, 0, numFonts)
+	for i := range numFonts {
+		info, err := readFace(r, int64(binary.BigEndian.Uint32(offsets[4*i:])))
+		if err != nil {
+			return nil, fmt.Errorf("collection face %d: %w", i, err)
+		}
+		faces = append(faces, info)
+	}
+	return faces, nil
+}
+
+type sfntTable struct {
+	offset int64
+	length int64
+}
+
+// readFace parses the table directory at offset and the tables of interest
+// that it points to.
+func readFace(r io.ReaderAt, offset int64) (FontInfo, error) {
+	var info FontInfo
+
+	var dir [12]byte
+	if _, err := r.ReadAt(dir[:], offset); err != nil {
+		return info, fmt.Errorf("failed to read table directory: %w", err)
+	}
+	if !isSfntMagic(dir[:]) {
+		return info, ErrNotSfnt
+	}
+	numTables := int(binary.BigEndian.Uint16(dir[4:]))
+	records := make([]byte, 16*numTables)
+	if _, err := r.ReadAt(records, offset+12); err != nil {
+		return info, fmt.Errorf("failed to read table records: %w", err)
+	}
+	tables := make(map[string]sfntTable, numTables)
+	for i := range numTables {
+		rec := records[16*i:]
+		tables[string(rec[:4])] = sfntTable{
+			offset: int64(binary.BigEndian.Uint32(rec[8:])),
+			length: int64(binary.BigEndian.Uint32(rec[12:])),
+		}
+	}
+
+	name, ok := tables["name"]
+	if !ok {
+		return info, errors.New("font has no name table")
+	}
+	names, err := readNames(r, name)
+	if err != nil {
+		return info, err
+	}
+	info.Family = names[nameFamily]
+	info.Subfamily = names[nameSubfamily]
+	info.TypographicFamily = names[nameTypographicFamily]
+	info.TypographicSubfamily = names[nameTypographicSubfamily]
+	info.FullName = names[nameFullName]
+	info.PostScriptName = names[namePostScript]
+	info.Version = names[nameVersion]
+
+	if head, ok := tables["head"]; ok && head.length >= 8 {
+		var b [4]byte
+		if _, err := r.ReadAt(b[:], head.offset+4); err != nil {
+			return info, fmt.Errorf("failed to read head table: %w", err)
+		}
+		// fontRevision is a 16.16 fixed-point number; font tools conventionally
+		// show it with three decimals.
+		revision := float64(int32(binary.BigEndian.Uint32(b[:]))) / 65536
+		info.FontRevision = math.Round(revision*1000) / 1000
+	}
+
+	if os2, ok := tables["OS/2"]; ok && os2.length >= 10 {
+		b := make([]byte, min(os2.length, 62))
+		if _, err := r.ReadAt(b, os2.offset); err != nil {
+			return info, fmt.Errorf("failed to read OS/2 table: %w", err)
+		}
+		info.FsType = FsType(binary.BigEndian.Uint16(b[8:]))
+		if len(b) >= 62 {
+			info.VendorID = strings.TrimRight(string(b[58:62]), " \x00")
+		}
+	}
+	info.Embedding = info.FsType.Embedding()
+
+	return info, nil
+}
+
+// nameScore ranks a name record so that the Windows US English string is
+// preferred, then any other Windows string, then Unicode and finally Mac
+// Roman ones. Records that cannot be decoded score zero.
+func nameScore(platform, encoding, language uint16) int {
+	switch platform {
+	case 3:
+		if encoding != 0 && encoding != 1 && encoding != 10 {
+			return 0
+		}
+		if language == 0x0409 {
+			return 4
+		}
+		return 3
+	case 0:
+		return 2
+	case 1:
+		if encoding == 0 && language == 0 {
+			return 1
+		}
+	}
+	return 0
+}
+
+func readNames(r io.ReaderAt, t sfntTable) (map[uint16]string, error) {
+	if t.length < 6 || t.length > 1<<24 {
+		return nil, fmt.Errorf("invalid name table length %d", t.length)
+	}
+	data := make([]byte, t.length)
+	if _, err := r.ReadAt(data, t.offset); err != nil {
+		return nil, fmt.Errorf("failed to read name table: %w", err)
+	}
+	count := int(binary.BigEndian.Uint16(data[2:]))
+	storage := int(binary.BigEndian.Uint16(data[4:]))
+	if 6+12*count > len(data) {
+		return nil, errors.New("name table records out of bounds")
+	}
+
+	names := make(map[uint16]string)
+	scores := make(map[uint16]int)
+	for i := range count {
+		rec := data[6+12*i:]
+		platform := binary.BigEndian.Uint16(rec[0:])
+		encoding := binary.BigEndian.Uint16(rec[2:])
+		language := binary.BigEndian.Uint16(rec[4:])
+		id := binary.BigEndian.Uint16(rec[6:])
+		length := int(binary.BigEndian.Uint16(rec[8:]))
+		offset := storage + int(binary.BigEndian.Uint16(rec[10:]))
+
+		score := nameScore(platform, encoding, language)
+		if score <= scores[id] || offset+length > len(data) {
+			continue
+		}
+		raw := data[offset : offset+length]
+		var s string
+		if platform == 1 {
+			decoded, err := charmap.Macintosh.NewDecoder().Bytes(raw)
+			if err != nil {
+				continue
+			}
+			s = string(decoded)
+		} else {
+			u := make([]uint16, len(raw)/2)
+			for j := range u {
+				u[j] = binary.BigEndian.Uint16(raw[2*j:])
+			}
+			s = string(utf16.Decode(u))
+		}
+		names[id] = s
+		scores[id] = score
+	}
+	return names, nil
+}
@@ -0,0 +1,256 @@
+package winfonts
+
+import (
+	"bytes"
+	"encoding/binary"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+// nameRecord is a record of a test name table.
+type nameRecord struct {
+	platform, encoding, language, id uint16
+	value                            string
+}
+
+// nameTable encodes records as a name table, with Mac Roman strings on
+// platform 1 and UTF-16BE ones on the others.
+func nameTable(records ...nameRecord) []byte {
+	var storage bytes.Buffer
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, []uint16{0, uint16(len(records)), uint16(6 + 12*len(records))})
+	for _, rec := range records {
+		var raw []byte
+		if rec.platform == 1 {
+			raw = []byte(rec.value)
+		} else {
+			for _, u := range utf16.Encode([]rune(rec.value)) {
+				raw = binary.BigEndian.AppendUint16(raw, u)
+			}
+		}
+		binary.Write(&buf, binary.BigEndian, []uint16{
+			rec.platform, rec.encoding, rec.language, rec.id,
+			uint16(len(raw)), uint16(storage.Len()),
+		})
+		storage.Write(raw)
+	}
+	buf.Write(storage.Bytes())
+	return buf.Bytes()
+}
+
+// windowsNames returns Windows US English name records for names, by name ID.
+func windowsNames(names map[uint16]string) []nameRecord {
+	var records []nameRecord
+	for id, value := range names {
+		records = append(records, nameRecord{3, 1, 0x0409, id, value})
+	}
+	slices.SortFunc(records, func(a, b nameRecord) int { return int(a.id) - int(b.id) })
+	return records
+}
+
+// headTable returns a head table with the given 16.16 fontRevision.
+func headTable(revision uint32) []byte {
+	head := make([]byte, 54)
+	binary.BigEndian.PutUint32(head, 0x00010000)
+	binary.BigEndian.PutUint32(head[4:], revision)
+	return head
+}
+
+// os2Table returns an OS/2 table with the given fsType and vendor ID.
+func os2Table(fsType FsType, vendor string) []byte {
+	os2 := make([]byte, 78)
+	binary.BigEndian.PutUint16(os2[8:], uint16(fsType))
+	copy(os2[58:62], vendor)
+	return os2
+}
+
+// sfntFace encodes a table directory and its tables, for a face that starts
+// at base in the file.
+func sfntFace(base int, tables map[string][]byte) []byte {
+	tags := slices.Sorted(maps.Keys(tables))
+	var dir, data bytes.Buffer
+	binary.Write(&dir, binary.BigEndian, []uint16{0x0001, 0x0000, uint16(len(tags)), 0, 0, 0})
+	offset := base + 12 + 16*len(tags)
+	for _, tag := range tags {
+		dir.WriteString(tag)
+		binary.Write(&dir, binary.BigEndian, []uint32{0, uint32(offset + data.Len()), uint32(len(tables[tag]))})
+		data.Write(tables[tag])
+		for data.Len()%4 != 0 {
+			data.WriteByte(0)
+		}
+	}
+	return append(dir.Bytes(), data.Bytes()...)
+}
+
+// ttc encodes a TrueType Collection of faces, each given as its tables.
+func ttc(faces ...map[string][]byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("ttcf")
+	binary.Write(&buf, binary.BigEndian, []uint32{0x00010000, uint32(len(faces))})
+	header := buf.Len() + 4*len(faces)
+	var body bytes.Buffer
+	for _, face := range faces {
+		binary.Write(&buf, binary.BigEndian, uint32(header+body.Len()))
+		body.Write(sfntFace(header+body.Len(), face))
+	}
+	return append(buf.Bytes(), body.Bytes()...)
+}
+
+func testFace(family string) map[string][]byte {
+	return map[string][]byte{
+		"name": nameTable(windowsNames(map[uint16]string{
+			nameFamily:               family,
+			nameSubfamily:            "Bold",
+			nameFullName:             family + " Bold",
+			nameVersion:              "Version 1.50",
+			namePostScript:           strings.ReplaceAll(family, " ", "") + "-Bold",
+			nameTypographicFamily:    family + " Display",
+			nameTypographicSubfamily: "Heavy",
+		})...),
+		"head": headTable(0x00018000),
+		"OS/2": os2Table(FsTypeEditable|FsTypeNoSubsetting, "TST "),
+	}
+}
+
+func TestReadFontInfo(t *testing.T) {
+	faces, err := ReadFontInfo(bytes.NewReader(sfntFace(0, testFace("Test Sans"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := FontInfo{
+		Family:               "Test Sans",
+		Subfamily:            "Bold",
+		TypographicFamily:    "Test Sans Display",
+		TypographicSubfamily: "Heavy",
+		FullName:             "Test Sans Bold",
+		PostScriptName:       "TestSans-Bold",
+		Version:              "Version 1.50",
+		FontRevision:         1.5,
+		VendorID:             "TST",
+		FsType:               FsTypeEditable | FsTypeNoSubsetting,
+		Embedding:            "editable",
+	}
+	if len(faces) != 1 || faces[0] != want {
+		t.Errorf("got %+v, want %+v", faces, want)
+	}
+}
+
+func TestReadFontInfoCollection(t *testing.T) {
+	families := []string{"Test Sans", "Test Serif", "Test Mono"}
+	var tables []map[string][]byte
+	for _, family := range families {
+		tables = append(tables, testFace(family))
+	}
+	faces, err := ReadFontInfo(bytes.NewReader(ttc(tables...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(faces) != len(families) {
+		t.Fatalf("got %d faces, want %d", len(faces), len(families))
+	}
+	for i, face := range faces {
+		if face.Family != families[i] || face.FontRevision != 1.5 || face.VendorID != "TST" {
+			t.Errorf("face %d: got %+v, want family %s", i, face, families[i])
+		}
+	}
+}
+
+func TestReadFontInfoNamePriority(t *testing.T) {
+	mac := nameRecord{1, 0, 0, nameFamily, "Caf\x8e Mac"} // é in Mac Roman
+	unicode := nameRecord{0, 3, 0, nameFamily, "Café Unicode"}
+	windows := nameRecord{3, 1, 0x040c, nameFamily, "Café Windows French"}
+	windowsUS := nameRecord{3, 1, 0x0409, nameFamily, "Café Windows"}
+	undecodable := nameRecord{3, 3, 0x0409, nameFamily, "Café PRC"}
+	for _, tt := range []struct {
+		name    string
+		records []nameRecord
+		want    string
+	}{
+		{"Mac Roman only", []nameRecord{mac}, "Café Mac"},
+		{"Unicode over Mac Roman", []nameRecord{mac, unicode}, "Café Unicode"},
+		{"Windows over Unicode", []nameRecord{unicode, windows}, "Café Windows French"},
+		{"Windows US English first", []nameRecord{windowsUS, windows, unicode, mac}, "Café Windows"},
+		{"Windows US English last", []nameRecord{mac, unicode, windows, windowsUS}, "Café Windows"},
+		{"unsupported Windows encoding", []nameRecord{mac, undecodable}, "Café Mac"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			face := map[string][]byte{"name": nameTable(tt.records...)}
+			faces, err := ReadFontInfo(bytes.NewReader(sfntFace(0, face)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := faces[0].Family; got != tt.want {
+				t.Errorf("got family %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadFontInfoCorrupt(t *testing.T) {
+	valid := sfntFace(0, testFace("Test Sans"))
+	// moveTable returns valid with the directory record of tag pointing at
+	// offset, with length bytes.
+	moveTable := func(tag string, offset, length uint32) []byte {
+		data := bytes.Clone(valid)
+		i := bytes.Index(data[:12+16*3], []byte(tag))
+		binary.BigEndian.PutUint32(data[i+8:], offset)
+		binary.BigEndian.PutUint32(data[i+12:], length)
+		return data
+	}
+	noName := testFace("Test Sans")
+	delete(noName, "name")
+	shortNames := testFace("Test Sans")
+	binary.BigEndian.PutUint16(shortNames["name"][2:], 1000)
+	badCollection := ttc(testFace("Test Sans"))
+	binary.BigEndian.PutUint32(badCollection[12:], 1<<30)
+	hugeCollection := ttc(testFace("Test Sans"))
+	binary.BigEndian.PutUint32(hugeCollection[8:], 1<<20)
+
+	for _, tt := range []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"not a font", []byte("this is not a font at all")},
+		{"truncated table directory", valid[:20]},
+		{"truncated tables", valid[:len(valid)-40]},
+		{"no name table", sfntFace(0, noName)},
+		{"name table past the end", moveTable("name", uint32(len(valid)), 100)},
+		{"name table too short", moveTable("name", 12, 4)},
+		{"name records past the table", sfntFace(0, shortNames)},
+		{"head table past the end", moveTable("head", uint32(len(valid))-4, 54)},
+		{"OS/2 table past the end", moveTable("OS/2", 1<<31, 78)},
+		{"collection face past the end", badCollection},
+		{"collection too large", hugeCollection},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if faces, err := ReadFontInfo(bytes.NewReader(tt.data)); err == nil {
+				t.Errorf("got %+v, want an error", faces)
+			}
+		})
+	}
+}
+
+func TestFsTypeEmbedding(t *testing.T) {
+	for _, tt := range []struct {
+		fsType FsType
+		want   string
+	}{
+		{0, "installable"},
+		{FsTypeNoSubsetting | FsTypeBitmapOnly, "installable"},
+		{FsTypeRestricted, "restricted"},
+		{FsTypeRestricted | FsTypeBitmapOnly, "restricted"},
+		{FsTypePreviewPrint, "preview-print"},
+		{FsTypeRestricted | FsTypePreviewPrint, "preview-print"},
+		{FsTypeEditable, "editable"},
+		{FsTypePreviewPrint | FsTypeEditable, "editable"},
+		{FsTypeRestricted | FsTypeEditable | FsTypeNoSubsetting, "editable"},
+	} {
+		if got := tt.fsType.Embedding(); got != tt.want {
+			t.Errorf("FsType(%#04x).Embedding() = %s, want %s", uint16(tt.fsType), got, tt.want)
+		}
+	}
+}
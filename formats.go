@@ -0,0 +1,133 @@
+package winfonts
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// FontFormat identifies a family of font files the extractor knows how to
+// recognise.
+type FontFormat string
+
+const (
+	FormatTrueType   FontFormat = "ttf"
+	FormatOpenType   FontFormat = "otf"
+	FormatCollection FontFormat = "ttc"
+	FormatBitmap     FontFormat = "fon"
+)
+
+// DefaultFormats is the set of formats extracted when none is configured.
+var DefaultFormats = []FontFormat{
+	FormatTrueType,
+	FormatOpenType,
+	FormatCollection,
+	FormatBitmap,
+}
+
+// magicPeekSize is how much of a file is inspected to confirm its format.
+// It is large enough to reach the NE header of every .fon Windows ships.
+const magicPeekSize = 4096
+
+// maxNEOffset bounds how far into a .fon file its NE header is looked for.
+const maxNEOffset = 1 << 16
+
+// fntHeaderSize is the size of the header of a version 1 FNT resource, the
+// smallest a .fnt file can be.
+const fntHeaderSize = 117
+
+var formatExtensions = map[string]FontFormat{
+	".ttf": FormatTrueType,
+	".otf": FormatOpenType,
+	".ttc": FormatCollection,
+	".fon": FormatBitmap,
+	".fnt": FormatBitmap,
+}
+
+// ParseFontFormat converts a user supplied name such as "ttf" or ".TTC" into
+// a FontFormat.
+func ParseFontFormat(s string) (FontFormat, error) {
+	ext := "." + strings.TrimPrefix(strings.ToLower(strings.TrimSpace(s)), ".")
+	format, ok := formatExtensions[ext]
+	if !ok {
+		return "", fmt.Errorf("unknown font format %q", s)
+	}
+	return format, nil
+}
+
+// formatForName returns the format implied by the file extension of name,
+// ignoring case since WIM images frequently store names like ARIAL.TTF.
+func formatForName(name string) (FontFormat, bool) {
+	format, ok := formatExtensions[strings.ToLower(filepath.Ext(name))]
+	return format, ok
+}
+
+// magicLen returns how many bytes of a file of the given size matchMagic needs
+// to see, given its first bytes hdr. It is more than len(hdr) only when the
+// NE header of a .fon file lies beyond them.
+func (f FontFormat) magicLen(hdr []byte, size int64) int {
+	if f != FormatBitmap || len(hdr) < 0x40 || !bytes.HasPrefix(hdr, []byte("MZ")) {
+		return len(hdr)
+	}
+	end := int64(binary.LittleEndian.Uint32(hdr[0x3c:])) + 2
+	if end > size || end > maxNEOffset {
+		return len(hdr)
+	}
+	return max(int(end), len(hdr))
+}
+
+// matchMagic reports whether hdr, the first bytes of a file of the given
+// size, looks like a font of format f.
+func (f FontFormat) matchMagic(hdr []byte, size int64) bool {
+	switch f {
+	case FormatTrueType, FormatOpenType:
+		return isSfntMagic(hdr)
+	case FormatCollection:
+		return bytes.HasPrefix(hdr, []byte("ttcf"))
+	case FormatBitmap:
+		return isNEFont(hdr) || isRawFnt(hdr, size)
+	}
+	return false
+}
+
+func isSfntMagic(hdr []byte) bool {
+	if len(hdr) < 4 {
+		return false
+	}
+	switch string(hdr[:4]) {
+	case "\x00\x01\x00\x00", "OTTO", "true":
+		return true
+	}
+	return false
+}
+
+// isNEFont recognises .fon files, which are 16-bit NE executables carrying
+// FNT resources. hdr must reach the NE header the MZ header points to, as
+// magicLen ensures.
+func isNEFont(hdr []byte) bool {
+	if len(hdr) < 0x40 || !bytes.HasPrefix(hdr, []byte("MZ")) {
+		return false
+	}
+	neOffset := int64(binary.LittleEndian.Uint32(hdr[0x3c:]))
+	if neOffset+2 > int64(len(hdr)) {
+		return false
+	}
+	return bytes.Equal(hdr[neOffset:neOffset+2], []byte("NE"))
+}
+
+// isRawFnt recognises bare .fnt resources by their dfVersion field and by
+// their dfSize field, which cannot exceed the size of the file.
+func isRawFnt(hdr []byte, size int64) bool {
+	if len(hdr) < 6 {
+		return false
+	}
+	switch binary.LittleEndian.Uint16(hdr) {
+	case 0x0100, 0x0200, 0x0300:
+	default:
+		return false
+	}
+	dfSize := int64(binary.LittleEndian.Uint32(hdr[2:]))
+	return dfSize >= fntHeaderSize && dfSize <= size
+}
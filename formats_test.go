@@ -0,0 +1,57 @@
+package winfonts
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// neFont returns an MZ stub whose NE header is at neOffset, in a file of
+// size bytes.
+func neFont(neOffset, size int, signature string) []byte {
+	data := make([]byte, size)
+	copy(data, "MZ")
+	binary.LittleEndian.PutUint32(data[0x3c:], uint32(neOffset))
+	if neOffset+2 <= size {
+		copy(data[neOffset:], signature)
+	}
+	return data
+}
+
+// rawFnt returns a version 2 FNT resource whose dfSize is dfSize, in a file
+// of size bytes.
+func rawFnt(dfSize, size int) []byte {
+	data := make([]byte, size)
+	binary.LittleEndian.PutUint16(data, 0x0200)
+	binary.LittleEndian.PutUint32(data[2:], uint32(dfSize))
+	return data
+}
+
+func TestBitmapMagic(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		data []byte
+		want bool
+	}{
+		{"NE header", neFont(0x80, 0x200, "NE"), true},
+		{"NE header past the peek", neFont(5000, 6000, "NE"), true},
+		{"PE header", neFont(0x80, 0x200, "PE"), false},
+		{"NE offset past the peek without NE", neFont(5000, 6000, "XX"), false},
+		{"NE offset past the file", neFont(5000, 4500, "NE"), false},
+		{"NE offset out of bounds", neFont(1<<20, 6000, "NE"), false},
+		{"fnt", rawFnt(300, 300), true},
+		{"fnt shorter than dfSize", rawFnt(300, 200), false},
+		{"fnt with a tiny dfSize", rawFnt(6, 300), false},
+		{"random data", []byte{0x01, 0x00, 0xff, 0xff, 0xff, 0xff, 0x00}, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			size := int64(len(tt.data))
+			hdr := tt.data[:min(len(tt.data), magicPeekSize)]
+			if n := FormatBitmap.magicLen(hdr, size); n > len(hdr) {
+				hdr = tt.data[:n]
+			}
+			if got := FormatBitmap.matchMagic(hdr, size); got != tt.want {
+				t.Errorf("matchMagic = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
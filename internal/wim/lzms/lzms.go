@@ -0,0 +1,782 @@
+// Package lzms implements a decompressor for LZMS, the compression format used
+// by the solid resources of ESD files and by WIM files captured with
+// /compress:recovery.
+//
+// LZMS combines LZ77 and "delta" matches with an adaptive range coder for the
+// item type decisions and adaptive Huffman codes for literals, lengths and
+// offsets. The format is not officially documented; this implementation
+// follows the description given by wimlib.
+package lzms
+
+import (
+	"encoding/binary"
+	"errors"
+	"slices"
+)
+
+const (
+	numLZReps    = 3
+	numDeltaReps = 3
+
+	numMainStates  = 16
+	numMatchStates = 32
+	numLZStates    = 64
+	numRepStates   = 64
+	numDeltaStates = 64
+
+	probabilityBits        = 6
+	probabilityDenominator = 1 << probabilityBits
+	initialProbability     = 48
+	initialRecentBits      = 0x0000000055555555
+
+	numLiteralSyms    = 256
+	numLengthSyms     = 54
+	numDeltaPowerSyms = 8
+	maxNumOffsetSyms  = 799
+	maxCodewordLen    = 15
+
+	literalRebuildFreq     = 1024
+	lzOffsetRebuildFreq    = 1024
+	lengthRebuildFreq      = 512
+	deltaOffsetRebuildFreq = 1024
+	deltaPowerRebuildFreq  = 512
+
+	x86MaxTranslationOffset = 1023
+	x86IDWindowSize         = 65535
+)
+
+var errCorrupt = errors.New("LZMS data corrupt")
+
+// offsetSlotDeltaRuns and lengthSlotDeltaRuns describe the slot base tables:
+// entry i is the number of consecutive slots whose bases are 1<<i apart.
+var (
+	offsetSlotDeltaRuns = [...]int{
+		9, 0, 9, 7, 10, 15, 15, 20,
+		20, 30, 33, 40, 42, 45, 60, 73,
+		80, 85, 95, 105, 6,
+	}
+	lengthSlotDeltaRuns = [...]int{
+		27, 4, 6, 4, 5, 2, 1, 1,
+		1, 1, 1, 0, 0, 0, 0, 0,
+		1,
+	}
+)
+
+var (
+	offsetSlotBase  [maxNumOffsetSyms + 1]uint32
+	offsetExtraBits [maxNumOffsetSyms]uint8
+	lengthSlotBase  [numLengthSyms + 1]uint32
+	lengthExtraBits [numLengthSyms]uint8
+)
+
+func init() {
+	decodeSlotBases(offsetSlotBase[:], offsetExtraBits[:], offsetSlotDeltaRuns[:], 0x7fffffff)
+	decodeSlotBases(lengthSlotBase[:], lengthExtraBits[:], lengthSlotDeltaRuns[:], 0x400108ab)
+}
+
+func decodeSlotBases(bases []uint32, extraBits []uint8, runs []int, final uint32) {
+	var base uint32
+	slot := 0
+	for order, run := range runs {
+		for range run {
+			base += 1 << order
+			if slot > 0 {
+				extraBits[slot-1] = uint8(order)
+			}
+			bases[slot] = base
+			slot++
+		}
+	}
+	bases[slot] = final
+	extraBits[slot-1] = uint8(bsr32(final - bases[slot-1]))
+}
+
+func bsr32(v uint32) int {
+	n := -1
+	for v != 0 {
+		v >>= 1
+		n++
+	}
+	return n
+}
+
+// numOffsetSlots returns the number of offset slots needed to express any
+// match offset in a buffer of the given size.
+func numOffsetSlots(size int) int {
+	if size < 2 {
+		return 0
+	}
+	offset := uint32(size - 1)
+	slot, _ := slices.BinarySearchFunc(offsetSlotBase[:maxNumOffsetSyms], offset, func(base, target uint32) int {
+		if base <= target {
+			return -1
+		}
+		return 1
+	})
+	return slot
+}
+
+type probabilityEntry struct {
+	numRecentZeroBits uint32
+	recentBits        uint64
+}
+
+func (p *probabilityEntry) reset() {
+	p.numRecentZeroBits = initialProbability
+	p.recentBits = initialRecentBits
+}
+
+// probability returns the chance, out of probabilityDenominator, that the
+// next bit is zero. 0% and 100% are not allowed.
+func (p *probabilityEntry) probability() uint32 {
+	prob := p.numRecentZeroBits
+	if prob == 0 {
+		return 1
+	}
+	if prob == probabilityDenominator {
+		return probabilityDenominator - 1
+	}
+	return prob
+}
+
+func (p *probabilityEntry) update(bit uint32) {
+	oldest := uint32(p.recentBits >> (probabilityDenominator - 1))
+	p.numRecentZeroBits = p.numRecentZeroBits + oldest - bit
+	p.recentBits = p.recentBits<<1 | uint64(bit)
+}
+
+// rangeDecoder reads the range coded item type decisions, which are stored as
+// little-endian 16-bit words from the start of the compressed data.
+type rangeDecoder struct {
+	rng  uint32
+	code uint32
+	in   []byte
+	pos  int
+}
+
+func (rd *rangeDecoder) init(in []byte) {
+	rd.rng = 0xffffffff
+	rd.code = uint32(binary.LittleEndian.Uint16(in))<<16 | uint32(binary.LittleEndian.Uint16(in[2:]))
+	rd.in = in
+	rd.pos = 4
+}
+
+func (rd *rangeDecoder) decodeBit(state *uint32, numStates uint32, probs []probabilityEntry) uint32 {
+	entry := &probs[*state]
+	*state = (*state << 1) & (numStates - 1)
+
+	prob := entry.probability()
+
+	if rd.rng&0xffff0000 == 0 {
+		rd.rng <<= 16
+		rd.code <<= 16
+		if rd.pos+2 <= len(rd.in) {
+			rd.code |= uint32(binary.LittleEndian.Uint16(rd.in[rd.pos:]))
+			rd.pos += 2
+		}
+	}
+
+	bound := (rd.rng >> probabilityBits) * prob
+	if rd.code < bound {
+		rd.rng = bound
+		entry.update(0)
+		return 0
+	}
+	rd.rng -= bound
+	rd.code -= bound
+	entry.update(1)
+	*state |= 1
+	return 1
+}
+
+// bitReader reads the Huffman coded symbols and extra bits, which are stored
+// as little-endian 16-bit words read backwards from the end of the compressed
+// data, most significant bit first.
+type bitReader struct {
+	buf      uint64
+	bitsleft uint
+	in       []byte
+	pos      int
+}
+
+func (br *bitReader) init(in []byte) {
+	br.buf = 0
+	br.bitsleft = 0
+	br.in = in
+	br.pos = len(in)
+}
+
+// ensure makes at least n <= 48 bits available. Past the start of the input
+// the stream is padded with zeros.
+func (br *bitReader) ensure(n uint) {
+	for br.bitsleft < n {
+		if br.pos >= 2 {
+			br.pos -= 2
+			br.buf |= uint64(binary.LittleEndian.Uint16(br.in[br.pos:])) << (48 - br.bitsleft)
+		}
+		br.bitsleft += 16
+	}
+}
+
+func (br *bitReader) peek(n uint) uint32 {
+	return uint32(br.buf >> 1 >> (63 - n))
+}
+
+func (br *bitReader) remove(n uint) {
+	br.buf <<= n
+	br.bitsleft -= n
+}
+
+func (br *bitReader) readBits(n uint) uint32 {
+	if n == 0 {
+		return 0
+	}
+	br.ensure(n)
+	bits := br.peek(n)
+	br.remove(n)
+	return bits
+}
+
+// huffmanCode is an adaptive canonical Huffman code that is rebuilt from the
+// observed symbol frequencies every rebuildFreq symbols.
+type huffmanCode struct {
+	numSyms      int
+	rebuildFreq  int
+	untilRebuild int
+	tableBits    uint
+
+	freqs []uint32
+	lens  []uint8
+
+	// table maps the next tableBits bits to sym<<4 | len for codewords no
+	// longer than tableBits; zero entries fall back to the slow path.
+	table []uint16
+	// count[l] is the number of codewords of length l and sorted lists the
+	// symbols ordered by codeword length, then symbol value.
+	count  [maxCodewordLen + 1]uint16
+	sorted []uint16
+
+	// scratch space used while rebuilding
+	keys     []uint32
+	parent   []int32
+	nodeFreq []uint32
+	depth    []int32
+}
+
+func newHuffmanCode(maxSyms, rebuildFreq int, tableBits uint) *huffmanCode {
+	return &huffmanCode{
+		rebuildFreq: rebuildFreq,
+		tableBits:   tableBits,
+		freqs:       make([]uint32, maxSyms),
+		lens:        make([]uint8, max(maxSyms, 2)),
+		table:       make([]uint16, 1<<tableBits),
+		sorted:      make([]uint16, max(maxSyms, 2)),
+		keys:        make([]uint32, maxSyms),
+		parent:      make([]int32, maxSyms),
+		nodeFreq:    make([]uint32, maxSyms),
+		depth:       make([]int32, maxSyms),
+	}
+}
+
+func (c *huffmanCode) reset(numSyms int) {
+	c.numSyms = numSyms
+	for i := range numSyms {
+		c.freqs[i] = 1
+	}
+	c.rebuild()
+}
+
+func (c *huffmanCode) rebuild() {
+	c.makeCanonicalLens()
+	c.buildDecodeTable()
+	c.untilRebuild = c.rebuildFreq
+}
+
+// makeCanonicalLens assigns codeword lengths from the symbol frequencies. It
+// must produce exactly the lengths the compressor used, so it mirrors the
+// length-limited Huffman construction of the reference implementation,
+// including how it breaks ties.
+func (c *huffmanCode) makeCanonicalLens() {
+	n := c.numSyms
+	clear(c.lens)
+	if n == 0 {
+		return
+	}
+
+	keys := c.keys[:n]
+	for sym := range n {
+		keys[sym] = c.freqs[sym]<<10 | uint32(sym)
+	}
+	slices.Sort(keys)
+
+	if n == 1 {
+		sym := keys[0] & 0x3ff
+		other := uint32(1)
+		if sym != 0 {
+			other = sym
+		}
+		c.lens[0] = 1
+		c.lens[other] = 1
+		return
+	}
+
+	// Build the non-leaf nodes of the Huffman tree. Leaves are taken in
+	// order from keys, non-leaves in the order they are created; a leaf is
+	// preferred over a non-leaf of equal frequency.
+	parent := c.parent[:n-1]
+	nodeFreq := c.nodeFreq[:n-1]
+	i, b := 0, 0
+	for e := 0; e < n-1; e++ {
+		var freq uint32
+		for range 2 {
+			if i != n && (b == e || keys[i]>>10 <= nodeFreq[b]) {
+				freq += keys[i] >> 10
+				i++
+			} else {
+				freq += nodeFreq[b]
+				parent[b] = int32(e)
+				b++
+			}
+		}
+		nodeFreq[e] = freq
+	}
+
+	// Compute how many codewords have each length, walking the non-leaf
+	// nodes from the root down and clamping to the maximum length.
+	var lenCounts [maxCodewordLen + 1]int
+	lenCounts[1] = 2
+	root := n - 2
+	depth := c.depth[:n-1]
+	depth[root] = 0
+	for node := root - 1; node >= 0; node-- {
+		d := depth[parent[node]] + 1
+		depth[node] = d
+		l := int(d)
+		if l >= maxCodewordLen {
+			l = maxCodewordLen
+			for {
+				l--
+				if lenCounts[l] != 0 {
+					break
+				}
+			}
+		}
+		lenCounts[l]--
+		lenCounts[l+1] += 2
+	}
+
+	// Hand out the lengths, longest first, to the symbols in order of
+	// increasing frequency.
+	k := 0
+	for l := maxCodewordLen; l >= 1; l-- {
+		for range lenCounts[l] {
+			c.lens[keys[k]&0x3ff] = uint8(l)
+			k++
+		}
+	}
+}
+
+func (c *huffmanCode) buildDecodeTable() {
+	n := max(c.numSyms, 2)
+	clear(c.count[:])
+	for sym := range n {
+		c.count[c.lens[sym]]++
+	}
+	c.count[0] = 0
+
+	var offsets [maxCodewordLen + 2]uint16
+	for l := 1; l <= maxCodewordLen; l++ {
+		offsets[l+1] = offsets[l] + c.count[l]
+	}
+	for sym := range n {
+		if l := c.lens[sym]; l != 0 {
+			c.sorted[offsets[l]] = uint16(sym)
+			offsets[l]++
+		}
+	}
+
+	clear(c.table)
+	var next [maxCodewordLen + 1]uint32
+	for l := 2; l <= maxCodewordLen; l++ {
+		next[l] = (next[l-1] + uint32(c.count[l-1])) << 1
+	}
+	for sym := range n {
+		l := uint(c.lens[sym])
+		if l == 0 {
+			continue
+		}
+		codeword := next[l]
+		next[l]++
+		if l > c.tableBits {
+			continue
+		}
+		start := codeword << (c.tableBits - l)
+		end := (codeword + 1) << (c.tableBits - l)
+		entry := uint16(sym)<<4 | uint16(l)
+		for j := start; j < end; j++ {
+			c.table[j] = entry
+		}
+	}
+}
+
+func (c *huffmanCode) decode(br *bitReader) (int, error) {
+	br.ensure(maxCodewordLen)
+
+	var sym int
+	if entry := c.table[br.peek(c.tableBits)]; entry != 0 {
+		br.remove(uint(entry & 0xf))
+		sym = int(entry >> 4)
+	} else {
+		bits := br.peek(maxCodewordLen)
+		var first, index uint32
+		l := uint(1)
+		for ; l <= maxCodewordLen; l++ {
+			code := bits >> (maxCodewordLen - l)
+			count := uint32(c.count[l])
+			if code-first < count {
+				sym = int(c.sorted[index+code-first])
+				break
+			}
+			index += count
+			first = (first + count) << 1
+		}
+		if l > maxCodewordLen {
+			return 0, errCorrupt
+		}
+		br.remove(l)
+	}
+	if sym >= c.numSyms {
+		return 0, errCorrupt
+	}
+
+	c.freqs[sym]++
+	c.untilRebuild--
+	if c.untilRebuild == 0 {
+		c.rebuild()
+		for i := range c.numSyms {
+			c.freqs[i] = c.freqs[i]>>1 + 1
+		}
+	}
+	return sym, nil
+}
+
+// Decompressor decompresses LZMS chunks. It keeps its state tables between
+// calls so that decompressing many chunks does not allocate.
+type Decompressor struct {
+	rd rangeDecoder
+	br bitReader
+
+	mainProbs     [numMainStates]probabilityEntry
+	matchProbs    [numMatchStates]probabilityEntry
+	lzProbs       [numLZStates]probabilityEntry
+	lzRepProbs    [numLZReps - 1][numRepStates]probabilityEntry
+	deltaProbs    [numDeltaStates]probabilityEntry
+	deltaRepProbs [numDeltaReps - 1][numRepStates]probabilityEntry
+
+	literal     *huffmanCode
+	lzOffset    *huffmanCode
+	length      *huffmanCode
+	deltaOffset *huffmanCode
+	deltaPower  *huffmanCode
+
+	lastTargetUsages []int32
+}
+
+// NewDecompressor returns a Decompressor ready for use.
+func NewDecompressor() *Decompressor {
+	return &Decompressor{
+		literal:          newHuffmanCode(numLiteralSyms, literalRebuildFreq, 10),
+		lzOffset:         newHuffmanCode(maxNumOffsetSyms, lzOffsetRebuildFreq, 10),
+		length:           newHuffmanCode(numLengthSyms, lengthRebuildFreq, 9),
+		deltaOffset:      newHuffmanCode(maxNumOffsetSyms, deltaOffsetRebuildFreq, 10),
+		deltaPower:       newHuffmanCode(numDeltaPowerSyms, deltaPowerRebuildFreq, 7),
+		lastTargetUsages: make([]int32, 65536),
+	}
+}
+
+// Decompress decompresses the LZMS chunk in into out, whose length must be
+// the exact uncompressed size of the chunk.
+func Decompress(in, out []byte) error {
+	return NewDecompressor().Decompress(in, out)
+}
+
+func resetProbs(probs []probabilityEntry) {
+	for i := range probs {
+		probs[i].reset()
+	}
+}
+
+func (d *Decompressor) reset(outSize int) {
+	resetProbs(d.mainProbs[:])
+	resetProbs(d.matchProbs[:])
+	resetProbs(d.lzProbs[:])
+	for i := range d.lzRepProbs {
+		resetProbs(d.lzRepProbs[i][:])
+	}
+	resetProbs(d.deltaProbs[:])
+	for i := range d.deltaRepProbs {
+		resetProbs(d.deltaRepProbs[i][:])
+	}
+
+	numOffsets := numOffsetSlots(outSize)
+	d.literal.reset(numLiteralSyms)
+	d.lzOffset.reset(numOffsets)
+	d.length.reset(numLengthSyms)
+	d.deltaOffset.reset(numOffsets)
+	d.deltaPower.reset(numDeltaPowerSyms)
+}
+
+func (d *Decompressor) decodeLength() (uint32, error) {
+	slot, err := d.length.decode(&d.br)
+	if err != nil {
+		return 0, err
+	}
+	return lengthSlotBase[slot] + d.br.readBits(uint(lengthExtraBits[slot])), nil
+}
+
+func (d *Decompressor) decodeOffset(code *huffmanCode) (uint32, error) {
+	slot, err := code.decode(&d.br)
+	if err != nil {
+		return 0, err
+	}
+	return offsetSlotBase[slot] + d.br.readBits(uint(offsetExtraBits[slot])), nil
+}
+
+// Decompress decompresses the LZMS chunk in into out, whose length must be
+// the exact uncompressed size of the chunk.
+func (d *Decompressor) Decompress(in, out []byte) error {
+	if len(in)&1 != 0 || len(in) < 4 {
+		return errCorrupt
+	}
+	if len(out) > 1<<30 {
+		return errors.New("LZMS chunk too large")
+	}
+
+	d.rd.init(in)
+	d.br.init(in)
+	d.reset(len(out))
+
+	var recentLZOffsets [numLZReps + 1]uint32
+	for i := range recentLZOffsets {
+		recentLZOffsets[i] = uint32(i + 1)
+	}
+	var recentDeltaPairs [numDeltaReps + 1]uint64
+	for i := range recentDeltaPairs {
+		recentDeltaPairs[i] = uint64(i + 1)
+	}
+
+	// The LRU queues are updated one item late. Rather than delaying the
+	// updates, a repeat match that directly follows a match of the same
+	// kind reads its source from the next slot of the queue.
+	const (
+		itemLiteral = 0
+		itemLZ      = 1
+		itemDelta   = 2
+	)
+	prevItem := itemLiteral
+
+	var mainState, matchState, lzState, deltaState uint32
+	var lzRepStates [numLZReps - 1]uint32
+	var deltaRepStates [numDeltaReps - 1]uint32
+
+	pos := 0
+	for pos < len(out) {
+		if d.rd.decodeBit(&mainState, numMainStates, d.mainProbs[:]) == 0 {
+			sym, err := d.literal.decode(&d.br)
+			if err != nil {
+				return err
+			}
+			out[pos] = byte(sym)
+			pos++
+			prevItem = itemLiteral
+			continue
+		}
+
+		if d.rd.decodeBit(&matchState, numMatchStates, d.matchProbs[:]) == 0 {
+			var offset uint32
+			if d.rd.decodeBit(&lzState, numLZStates, d.lzProbs[:]) == 0 {
+				var err error
+				offset, err = d.decodeOffset(d.lzOffset)
+				if err != nil {
+					return err
+				}
+				recentLZOffsets[3] = recentLZOffsets[2]
+				recentLZOffsets[2] = recentLZOffsets[1]
+				recentLZOffsets[1] = recentLZOffsets[0]
+			} else {
+				shift := prevItem & 1
+				rep := 0
+				for rep < numLZReps-1 && d.rd.decodeBit(&lzRepStates[rep], numRepStates, d.lzRepProbs[rep][:]) != 0 {
+					rep++
+				}
+				offset = recentLZOffsets[rep+shift]
+				recentLZOffsets[rep+shift] = recentLZOffsets[rep]
+				for j := rep; j > 0; j-- {
+					recentLZOffsets[j] = recentLZOffsets[j-1]
+				}
+			}
+			recentLZOffsets[0] = offset
+			prevItem = itemLZ
+
+			length, err := d.decodeLength()
+			if err != nil {
+				return err
+			}
+			if int(offset) > pos || int(length) > len(out)-pos {
+				return errCorrupt
+			}
+			src := pos - int(offset)
+			for range length {
+				out[pos] = out[src]
+				pos++
+				src++
+			}
+			continue
+		}
+
+		var pair uint64
+		if d.rd.decodeBit(&deltaState, numDeltaStates, d.deltaProbs[:]) == 0 {
+			power, err := d.deltaPower.decode(&d.br)
+			if err != nil {
+				return err
+			}
+			rawOffset, err := d.decodeOffset(d.deltaOffset)
+			if err != nil {
+				return err
+			}
+			pair = uint64(power)<<32 | uint64(rawOffset)
+			recentDeltaPairs[3] = recentDeltaPairs[2]
+			recentDeltaPairs[2] = recentDeltaPairs[1]
+			recentDeltaPairs[1] = recentDeltaPairs[0]
+		} else {
+			shift := prevItem >> 1
+			rep := 0
+			for rep < numDeltaReps-1 && d.rd.decodeBit(&deltaRepStates[rep], numRepStates, d.deltaRepProbs[rep][:]) != 0 {
+				rep++
+			}
+			pair = recentDeltaPairs[rep+shift]
+			recentDeltaPairs[rep+shift] = recentDeltaPairs[rep]
+			for j := rep; j > 0; j-- {
+				recentDeltaPairs[j] = recentDeltaPairs[j-1]
+			}
+		}
+		recentDeltaPairs[0] = pair
+		prevItem = itemDelta
+
+		length, err := d.decodeLength()
+		if err != nil {
+			return err
+		}
+
+		power := uint32(pair >> 32)
+		rawOffset := uint32(pair)
+		if power >= 32 {
+			return errCorrupt
+		}
+		offset1 := uint32(1) << power
+		offset2 := rawOffset << power
+		offset := offset1 + offset2
+		if offset2>>power != rawOffset || offset < offset2 {
+			return errCorrupt
+		}
+		if int64(offset) > int64(pos) || int(length) > len(out)-pos {
+			return errCorrupt
+		}
+		p1 := pos - int(offset1)
+		p2 := pos - int(offset2)
+		p := pos - int(offset)
+		for range length {
+			out[pos] = out[p1] + out[p2] - out[p]
+			pos++
+			p1++
+			p2++
+			p++
+		}
+	}
+
+	x86Filter(out, d.lastTargetUsages)
+	return nil
+}
+
+// x86Filter undoes the translation of relative addresses in x86 call, jump
+// and load instructions into absolute addresses that the compressor applies
+// to improve compression of executable code.
+func x86Filter(data []byte, lastTargetUsages []int32) {
+	size := int32(len(data))
+	if size <= 16 {
+		return
+	}
+
+	closestTargetUsage := int32(-x86MaxTranslationOffset - 1)
+	for i := range lastTargetUsages {
+		lastTargetUsages[i] = -x86IDWindowSize - 1
+	}
+
+	// Like the reference implementation, never look at the first byte.
+	for i := int32(1); i < size-16; {
+		opcodeLen, maxTransOffset := x86Opcode(data[i:])
+		if maxTransOffset == 0 {
+			i += opcodeLen
+			continue
+		}
+
+		operand := data[i+opcodeLen:]
+		if i-closestTargetUsage <= maxTransOffset {
+			n := binary.LittleEndian.Uint32(operand)
+			binary.LittleEndian.PutUint32(operand, n-uint32(i))
+		}
+		target := uint16(i) + binary.LittleEndian.Uint16(operand)
+
+		i += opcodeLen + 4 - 1
+		if i-lastTargetUsages[target] <= x86IDWindowSize {
+			closestTargetUsage = i
+		}
+		lastTargetUsages[target] = i
+		i++
+	}
+}
+
+// x86Opcode recognises the instructions whose operand the x86 filter may
+// translate. It returns the opcode length and the maximum distance from the
+// last likely x86 instruction at which translation is still done; a zero
+// distance means the bytes are skipped without translation.
+func x86Opcode(p []byte) (int32, int32) {
+	switch p[0] {
+	case 0x48:
+		if p[1] == 0x8b {
+			if p[2] == 0x05 || p[2] == 0x0d {
+				// Load relative (x86_64)
+				return 3, x86MaxTranslationOffset
+			}
+		} else if p[1] == 0x8d {
+			if p[2]&0x07 == 0x05 {
+				// Load effective address relative (x86_64)
+				return 3, x86MaxTranslationOffset
+			}
+		}
+	case 0x4c:
+		if p[1] == 0x8d {
+			if p[2]&0x07 == 0x05 {
+				// Load effective address relative (x86_64)
+				return 3, x86MaxTranslationOffset
+			}
+		}
+	case 0xe8:
+		// Call relative
+		return 1, x86MaxTranslationOffset / 2
+	case 0xe9:
+		// Jump relative
+		return 5, 0
+	case 0xf0:
+		if p[1] == 0x83 && p[2] == 0x05 {
+			// Lock add relative
+			return 3, x86MaxTranslationOffset
+		}
+	case 0xff:
+		if p[1] == 0x15 {
+			// Call indirect
+			return 2, x86MaxTranslationOffset
+		}
+	}
+	return 1, 0
+}
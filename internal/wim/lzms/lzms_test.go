@@ -0,0 +1,270 @@
+package lzms
+
+import (
+	"bytes"
+	"encoding/binary"
+	"slices"
+	"testing"
+)
+
+// testEncoder produces LZMS chunks made of literals and repeat matches, the
+// way wimlib's compressor lays them out: range coded decisions in 16-bit
+// words from the start of the chunk, and Huffman codes in 16-bit words
+// written backwards from its end. Every code is used fewer times than its
+// rebuild frequency, so the codes keep the lengths they start with.
+type testEncoder struct {
+	rng       uint32
+	low       uint64
+	cache     uint16
+	cacheSize int
+	skipped   bool
+	words     []uint16
+
+	bitbuf   uint64
+	bitcount uint
+	bits     []uint16
+
+	mainProbs  [numMainStates]probabilityEntry
+	matchProbs [numMatchStates]probabilityEntry
+	lzProbs    [numLZStates]probabilityEntry
+	lzRepProbs [numRepStates]probabilityEntry
+	mainState  uint32
+	matchState uint32
+	lzState    uint32
+	lzRepState uint32
+
+	lengthCodes []uint32
+	lengthLens  []uint8
+}
+
+func newTestEncoder() *testEncoder {
+	e := &testEncoder{rng: 0xffffffff, cacheSize: 1}
+	resetProbs(e.mainProbs[:])
+	resetProbs(e.matchProbs[:])
+	resetProbs(e.lzProbs[:])
+	resetProbs(e.lzRepProbs[:])
+
+	c := newHuffmanCode(numLengthSyms, lengthRebuildFreq, 9)
+	c.reset(numLengthSyms)
+	e.lengthLens = c.lens[:numLengthSyms]
+	e.lengthCodes = canonicalCodes(e.lengthLens)
+	return e
+}
+
+// canonicalCodes assigns canonical codewords to the given lengths.
+func canonicalCodes(lens []uint8) []uint32 {
+	syms := make([]int, len(lens))
+	for i := range syms {
+		syms[i] = i
+	}
+	slices.SortStableFunc(syms, func(a, b int) int { return int(lens[a]) - int(lens[b]) })
+	codes := make([]uint32, len(lens))
+	var code uint32
+	prevLen := lens[syms[0]]
+	for _, sym := range syms {
+		code <<= lens[sym] - prevLen
+		prevLen = lens[sym]
+		codes[sym] = code
+		code++
+	}
+	return codes
+}
+
+func (e *testEncoder) shiftLow() {
+	if uint32(e.low) < 0xffff0000 || e.low>>32 != 0 {
+		for {
+			// The first word is a placeholder for the carry.
+			if e.skipped {
+				e.words = append(e.words, e.cache+uint16(e.low>>32))
+			}
+			e.skipped = true
+			e.cache = 0xffff
+			e.cacheSize--
+			if e.cacheSize == 0 {
+				break
+			}
+		}
+		e.cache = uint16(e.low >> 16)
+	}
+	e.cacheSize++
+	e.low = (e.low & 0xffff) << 16
+}
+
+func (e *testEncoder) encodeBit(bit uint32, state *uint32, numStates uint32, probs []probabilityEntry) {
+	entry := &probs[*state]
+	bound := (e.rng >> probabilityBits) * entry.probability()
+	if bit == 0 {
+		e.rng = bound
+	} else {
+		e.low += uint64(bound)
+		e.rng -= bound
+	}
+	entry.update(bit)
+	*state = (*state<<1 | bit) & (numStates - 1)
+	if e.rng <= 0xffff {
+		e.rng <<= 16
+		e.shiftLow()
+	}
+}
+
+func (e *testEncoder) putBits(bits uint32, n uint8) {
+	e.bitbuf = e.bitbuf<<n | uint64(bits)
+	e.bitcount += uint(n)
+	for e.bitcount >= 16 {
+		e.bitcount -= 16
+		e.bits = append(e.bits, uint16(e.bitbuf>>e.bitcount))
+	}
+}
+
+// literal encodes a literal. The literal code starts with all 256 symbols
+// at length 8, so each codeword is the byte itself.
+func (e *testEncoder) literal(b byte) {
+	e.encodeBit(0, &e.mainState, numMainStates, e.mainProbs[:])
+	e.putBits(uint32(b), 8)
+}
+
+// repeatMatch encodes a match of length up to 27 at the most recent LZ
+// offset, which is 1 at the start of a chunk.
+func (e *testEncoder) repeatMatch(length int) {
+	e.encodeBit(1, &e.mainState, numMainStates, e.mainProbs[:])
+	e.encodeBit(0, &e.matchState, numMatchStates, e.matchProbs[:])
+	e.encodeBit(1, &e.lzState, numLZStates, e.lzProbs[:])
+	e.encodeBit(0, &e.lzRepState, numRepStates, e.lzRepProbs[:])
+	slot := length - 1
+	e.putBits(e.lengthCodes[slot], e.lengthLens[slot])
+}
+
+func (e *testEncoder) finish() []byte {
+	for range 4 {
+		e.shiftLow()
+	}
+	if e.bitcount > 0 {
+		e.bits = append(e.bits, uint16(e.bitbuf<<(16-e.bitcount)))
+	}
+	words := e.words
+	for i := len(e.bits) - 1; i >= 0; i-- {
+		words = append(words, e.bits[i])
+	}
+	out := make([]byte, 2*len(words))
+	for i, w := range words {
+		binary.LittleEndian.PutUint16(out[2*i:], w)
+	}
+	return out
+}
+
+func compressLiterals(data []byte) []byte {
+	e := newTestEncoder()
+	for _, b := range data {
+		e.literal(b)
+	}
+	return e.finish()
+}
+
+func TestDecompressLiterals(t *testing.T) {
+	for _, data := range [][]byte{
+		[]byte("a"),
+		[]byte("Hello, LZMS!"),
+		bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. "), 20),
+	} {
+		out := make([]byte, len(data))
+		if err := Decompress(compressLiterals(data), out); err != nil {
+			t.Fatalf("%q: %v", data, err)
+		}
+		if !bytes.Equal(out, data) {
+			t.Errorf("decompressed %q, want %q", out, data)
+		}
+	}
+}
+
+func TestDecompressRepeatMatch(t *testing.T) {
+	e := newTestEncoder()
+	for _, b := range []byte("abc") {
+		e.literal(b)
+	}
+	e.repeatMatch(20)
+	e.literal('d')
+	want := []byte("abc" + string(bytes.Repeat([]byte("c"), 20)) + "d")
+
+	out := make([]byte, len(want))
+	if err := Decompress(e.finish(), out); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, want) {
+		t.Errorf("decompressed %q, want %q", out, want)
+	}
+}
+
+// x86Chunk returns a 32 byte chunk holding call instructions (E8) at the
+// given positions, with the given 32-bit operands.
+func x86Chunk(calls map[int]uint32) []byte {
+	data := make([]byte, 32)
+	for pos, operand := range calls {
+		data[pos] = 0xe8
+		binary.LittleEndian.PutUint32(data[pos+1:], operand)
+	}
+	return data
+}
+
+func TestDecompressX86(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		in   []byte
+		want []byte
+	}{
+		{
+			// The calls at 1 and 6 target the same address, 0x100,
+			// so the one at 11 is close enough to a likely call to
+			// have had its operand made absolute: 0x1234 - 11.
+			name: "translated",
+			in:   x86Chunk(map[int]uint32{1: 0xff, 6: 0xfa, 11: 0x1234}),
+			want: x86Chunk(map[int]uint32{1: 0xff, 6: 0xfa, 11: 0x1229}),
+		},
+		{
+			// The same pattern from the first byte, which the
+			// filter never looks at: the call at 5 is the first to
+			// target 0x100, so nothing is translated.
+			name: "opcode at the start",
+			in:   x86Chunk(map[int]uint32{0: 0x100, 5: 0xfb, 10: 0x1234}),
+			want: x86Chunk(map[int]uint32{0: 0x100, 5: 0xfb, 10: 0x1234}),
+		},
+		{
+			name: "too short",
+			in:   x86Chunk(map[int]uint32{1: 0xff, 6: 0xfa, 11: 0x1234})[:16],
+			want: x86Chunk(map[int]uint32{1: 0xff, 6: 0xfa, 11: 0x1234})[:16],
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			out := make([]byte, len(tt.want))
+			if err := Decompress(compressLiterals(tt.in), out); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(out, tt.want) {
+				t.Errorf("decompressed\n%x, want\n%x", out, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecompressCorrupt(t *testing.T) {
+	valid := compressLiterals([]byte("Hello, LZMS!"))
+	for _, tt := range []struct {
+		name string
+		in   []byte
+		size int
+	}{
+		{"odd length", valid[:len(valid)-1], 12},
+		{"too short", valid[:2], 12},
+	} {
+		if err := Decompress(tt.in, make([]byte, tt.size)); err == nil {
+			t.Errorf("%s: no error", tt.name)
+		}
+	}
+
+	// A repeat match longer than what is left of the output.
+	e := newTestEncoder()
+	e.literal('a')
+	e.repeatMatch(20)
+	if err := Decompress(e.finish(), make([]byte, 10)); err == nil {
+		t.Errorf("match past the end of the output: no error")
+	}
+}
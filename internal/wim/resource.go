@@ -0,0 +1,322 @@
+package wim
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/Microsoft/go-winio/wim/lzx"
+
+	"github.com/actions-precompiled/winfonts/internal/wim/lzms"
+	"github.com/actions-precompiled/winfonts/internal/wim/xpress"
+)
+
+type compression uint32
+
+// Compression formats, numbered as in the headers of solid resources.
+const (
+	compressionNone   compression = 0
+	compressionXpress compression = 1
+	compressionLZX    compression = 2
+	compressionLZMS   compression = 3
+)
+
+func (c compression) String() string {
+	switch c {
+	case compressionNone:
+		return "none"
+	case compressionXpress:
+		return "XPRESS"
+	case compressionLZX:
+		return "LZX"
+	case compressionLZMS:
+		return "LZMS"
+	}
+	return fmt.Sprintf("compression %d", uint32(c))
+}
+
+// solidResourceMagic is stored as the uncompressed size of blob table entries
+// that describe a solid resource rather than a blob.
+const solidResourceMagic = 0x100000000
+
+// solidHeader precedes the chunk table of a solid resource. Unlike normal
+// resources, solid resources record their own size and compression.
+type solidHeader struct {
+	OriginalSize int64
+	ChunkSize    uint32
+	Compression  compression
+}
+
+const solidHeaderSize = 16
+
+// resource is a stored, possibly compressed, range of a WIM file. Compressed
+// resources are split into independently compressed chunks, which lets any
+// part of a resource be read without decompressing what comes before it.
+type resource struct {
+	wim         *Reader
+	r           io.ReaderAt
+	offset      int64
+	storedSize  int64
+	size        int64
+	compression compression
+	chunkSize   int64
+	solid       bool
+
+	once   sync.Once
+	chunks []int64 // chunk start offsets within the resource, plus its end
+	err    error
+}
+
+// blob is the content of a file or stream: a range of the uncompressed data of
+// a resource. Outside of solid resources a blob spans its whole resource.
+type blob struct {
+	res    *resource
+	offset int64
+	size   int64
+}
+
+func (b blob) open() io.ReadCloser {
+	if b.res == nil {
+		return io.NopCloser(bytes.NewReader(nil))
+	}
+	return io.NopCloser(io.NewSectionReader(b.res, b.offset, b.size))
+}
+
+func (r *resource) numChunks() int64 {
+	return (r.size + r.chunkSize - 1) / r.chunkSize
+}
+
+func (r *resource) loadChunkTable() error {
+	r.once.Do(func() {
+		r.err = r.readChunkTable()
+	})
+	return r.err
+}
+
+// errChunkTableSize is returned for a resource whose size calls for more chunks
+// than its chunk table has room for in the stored data.
+var errChunkTableSize = &ParseError{Oper: "chunk table", Err: errors.New("chunk table larger than resource")}
+
+func (r *resource) readChunkTable() error {
+	// The table is stored within the resource, which bounds the number of
+	// chunks; checking it first keeps a corrupt size from allocating a
+	// huge table.
+	nchunks := r.numChunks()
+	if nchunks < 1 {
+		return &ParseError{Oper: "chunk table", Err: fmt.Errorf("invalid resource size %d", r.size)}
+	}
+	if r.solid {
+		if nchunks > (r.storedSize-solidHeaderSize)/4 {
+			return errChunkTableSize
+		}
+		// The table lists the compressed size of every chunk.
+		sizes := make([]uint32, nchunks)
+		section := io.NewSectionReader(r.r, r.offset+solidHeaderSize, 4*nchunks)
+		if err := binary.Read(section, binary.LittleEndian, sizes); err != nil {
+			return &ParseError{Oper: "chunk table", Err: err}
+		}
+		r.chunks = make([]int64, nchunks+1)
+		r.chunks[0] = solidHeaderSize + 4*nchunks
+		for i, size := range sizes {
+			r.chunks[i+1] = r.chunks[i] + int64(size)
+		}
+	} else {
+		// The table lists the offsets of all chunks but the first,
+		// relative to the end of the table.
+		entrySize := int64(4)
+		if r.size > 0xffffffff {
+			entrySize = 8
+		}
+		if nchunks-1 > r.storedSize/entrySize {
+			return errChunkTableSize
+		}
+		tableSize := (nchunks - 1) * entrySize
+		offsets := make([]int64, nchunks-1)
+		section := io.NewSectionReader(r.r, r.offset, tableSize)
+		var err error
+		if entrySize == 4 {
+			offsets32 := make([]uint32, nchunks-1)
+			err = binary.Read(section, binary.LittleEndian, offsets32)
+			for i, off := range offsets32 {
+				offsets[i] = int64(off)
+			}
+		} else {
+			err = binary.Read(section, binary.LittleEndian, offsets)
+		}
+		if err != nil {
+			return &ParseError{Oper: "chunk table", Err: err}
+		}
+		r.chunks = make([]int64, nchunks+1)
+		r.chunks[0] = tableSize
+		for i, off := range offsets {
+			r.chunks[i+1] = tableSize + off
+		}
+		r.chunks[nchunks] = r.storedSize
+	}
+	for i := range nchunks {
+		if r.chunks[i+1] < r.chunks[i] || r.chunks[i+1] > r.storedSize {
+			return &ParseError{Oper: "chunk table", Err: errors.New("chunk out of bounds")}
+		}
+	}
+	return nil
+}
+
+// ReadAt reads the uncompressed data of the resource.
+func (r *resource) ReadAt(p []byte, off int64) (int, error) {
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	if r.compression == compressionNone && !r.solid {
+		if remaining := r.size - off; int64(len(p)) > remaining {
+			n, err := r.r.ReadAt(p[:remaining], r.offset+off)
+			if err == nil {
+				err = io.EOF
+			}
+			return n, err
+		}
+		return r.r.ReadAt(p, r.offset+off)
+	}
+
+	if err := r.loadChunkTable(); err != nil {
+		return 0, err
+	}
+	n := 0
+	for n < len(p) && off < r.size {
+		data, err := r.wim.chunk(r, off/r.chunkSize)
+		if err != nil {
+			return n, err
+		}
+		copied := copy(p[n:], data[off%r.chunkSize:])
+		n += copied
+		off += int64(copied)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// decompressChunk reads and decompresses chunk i of r.
+func (r *resource) decompressChunk(i int64, d *decompressors) ([]byte, error) {
+	usize := min(r.chunkSize, r.size-i*r.chunkSize)
+	csize := r.chunks[i+1] - r.chunks[i]
+	// Chunks that would not shrink are stored as they are, so none is
+	// larger compressed.
+	if csize > usize {
+		return nil, fmt.Errorf("chunk %d is %d bytes compressed, more than its %d bytes", i, csize, usize)
+	}
+	in := make([]byte, csize)
+	if _, err := r.r.ReadAt(in, r.offset+r.chunks[i]); err != nil {
+		return nil, fmt.Errorf("failed to read chunk %d: %w", i, err)
+	}
+	if csize == usize {
+		// Chunks that did not shrink are stored uncompressed.
+		return in, nil
+	}
+
+	out := make([]byte, usize)
+	switch r.compression {
+	case compressionLZX:
+		if r.chunkSize != 32768 {
+			return nil, fmt.Errorf("unsupported LZX chunk size %d", r.chunkSize)
+		}
+		dr, err := lzx.NewReader(bytes.NewReader(in), int(usize))
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.ReadFull(dr, out)
+		dr.Close()
+		if err != nil {
+			return nil, err
+		}
+	case compressionXpress:
+		if d.xpress == nil {
+			d.xpress = xpress.NewDecompressor()
+		}
+		if err := d.xpress.Decompress(in, out); err != nil {
+			return nil, err
+		}
+	case compressionLZMS:
+		if d.lzms == nil {
+			d.lzms = lzms.NewDecompressor()
+		}
+		if err := d.lzms.Decompress(in, out); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported compression format %s", r.compression)
+	}
+	return out, nil
+}
+
+// decompressors holds the reusable decompressor state of a Reader.
+type decompressors struct {
+	xpress *xpress.Decompressor
+	lzms   *lzms.Decompressor
+}
+
+// maxCacheBytes bounds the memory used to keep recently decompressed chunks.
+// Solid resources use chunks of up to 64 MiB, and several files are usually
+// stored in each of them, so caching a few saves a lot of decompression.
+const maxCacheBytes = 192 << 20
+
+type chunkKey struct {
+	res   *resource
+	index int64
+}
+
+type chunkEntry struct {
+	key  chunkKey
+	data []byte
+}
+
+// chunkCache is a least recently used cache of decompressed chunks.
+type chunkCache struct {
+	m       sync.Mutex
+	d       decompressors
+	entries map[chunkKey]*list.Element
+	lru     list.List
+	bytes   int
+}
+
+func (r *Reader) chunk(res *resource, index int64) ([]byte, error) {
+	c := &r.cache
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	key := chunkKey{res, index}
+	if e, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(e)
+		return e.Value.(*chunkEntry).data, nil
+	}
+
+	data, err := res.decompressChunk(index, &c.d)
+	if err != nil {
+		return nil, &ParseError{Oper: "resource", Err: err}
+	}
+
+	if c.entries == nil {
+		c.entries = make(map[chunkKey]*list.Element)
+	}
+	c.entries[key] = c.lru.PushFront(&chunkEntry{key, data})
+	c.bytes += len(data)
+	for c.bytes > maxCacheBytes && c.lru.Len() > 1 {
+		e := c.lru.Back()
+		entry := c.lru.Remove(e).(*chunkEntry)
+		delete(c.entries, entry.key)
+		c.bytes -= len(entry.data)
+	}
+	return data, nil
+}
+
+func (c *chunkCache) reset() {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.entries = nil
+	c.lru.Init()
+	c.bytes = 0
+}
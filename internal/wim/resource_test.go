@@ -0,0 +1,69 @@
+package wim
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func TestResourceCorrupt(t *testing.T) {
+	table := func(offsets ...uint32) []byte {
+		var buf bytes.Buffer
+		binary.Write(&buf, binary.LittleEndian, offsets)
+		return buf.Bytes()
+	}
+	for _, tt := range []struct {
+		name string
+		data []byte
+		res  *resource
+	}{
+		{
+			name: "size too large for the chunk table",
+			data: make([]byte, 64),
+			res:  &resource{storedSize: 64, size: 1 << 50, chunkSize: 32768},
+		},
+		{
+			name: "solid size too large for the chunk table",
+			data: make([]byte, 64),
+			res:  &resource{storedSize: 64, size: 1 << 50, chunkSize: 32768, solid: true},
+		},
+		{
+			name: "solid resource shorter than its header",
+			data: make([]byte, 8),
+			res:  &resource{storedSize: 8, size: 100, chunkSize: 32768, solid: true},
+		},
+		{
+			name: "chunk offset past the resource",
+			data: append(table(1000), make([]byte, 60)...),
+			res:  &resource{storedSize: 64, size: 2 * 4096, chunkSize: 4096},
+		},
+		{
+			name: "chunk offsets out of order",
+			data: append(table(40, 20), make([]byte, 56)...),
+			res:  &resource{storedSize: 64, size: 3 * 4096, chunkSize: 4096},
+		},
+		{
+			name: "truncated chunk table",
+			data: table(20)[:2],
+			res:  &resource{storedSize: 64, size: 2 * 4096, chunkSize: 4096},
+		},
+		{
+			name: "chunk larger compressed than uncompressed",
+			data: make([]byte, 200),
+			res:  &resource{storedSize: 200, size: 100, chunkSize: 4096},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			res := tt.res
+			res.wim = &Reader{}
+			res.r = bytes.NewReader(tt.data)
+			res.compression = compressionXpress
+			_, err := res.ReadAt(make([]byte, 10), 0)
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				t.Errorf("got error %v, want a ParseError", err)
+			}
+		})
+	}
+}
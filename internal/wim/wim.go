@@ -0,0 +1,944 @@
+// Package wim implements a WIM file parser.
+//
+// It is derived from github.com/Microsoft/go-winio/wim (see LICENSE) and
+// extends it with what Windows installation media need beyond classic WIM
+// files: LZMS compression and the solid resources of ESD files. Resources are
+// read chunk by chunk, so opening a file deep inside an image only decompresses
+// the chunks it touches.
+package wim
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"time"
+	"unicode/utf16"
+)
+
+// File attribute constants from Windows.
+//
+//nolint:revive // var-naming: ALL_CAPS
+const (
+	FILE_ATTRIBUTE_READONLY      = 0x00000001
+	FILE_ATTRIBUTE_HIDDEN        = 0x00000002
+	FILE_ATTRIBUTE_SYSTEM        = 0x00000004
+	FILE_ATTRIBUTE_DIRECTORY     = 0x00000010
+	FILE_ATTRIBUTE_ARCHIVE       = 0x00000020
+	FILE_ATTRIBUTE_NORMAL        = 0x00000080
+	FILE_ATTRIBUTE_REPARSE_POINT = 0x00000400
+)
+
+var wimImageTag = [...]byte{'M', 'S', 'W', 'I', 'M', 0, 0, 0}
+
+type guid struct {
+	Data1 uint32
+	Data2 uint16
+	Data3 uint16
+	Data4 [8]byte
+}
+
+func (g guid) String() string {
+	return fmt.Sprintf("%08x-%04x-%04x-%02x%02x-%02x%02x%02x%02x%02x%02x",
+		g.Data1,
+		g.Data2,
+		g.Data3,
+		g.Data4[0],
+		g.Data4[1],
+		g.Data4[2],
+		g.Data4[3],
+		g.Data4[4],
+		g.Data4[5],
+		g.Data4[6],
+		g.Data4[7])
+}
+
+type resourceDescriptor struct {
+	FlagsAndCompressedSize uint64
+	Offset                 int64
+	OriginalSize           int64
+}
+
+type resFlag byte
+
+//nolint:deadcode,varcheck // need unused variables for iota to work
+const (
+	resFlagFree resFlag = 1 << iota
+	resFlagMetadata
+	resFlagCompressed
+	resFlagSpanned
+	resFlagSolid
+)
+
+func (r *resourceDescriptor) Flags() resFlag {
+	return resFlag(r.FlagsAndCompressedSize >> 56)
+}
+
+func (r *resourceDescriptor) CompressedSize() int64 {
+	return int64(r.FlagsAndCompressedSize & 0xffffffffffffff)
+}
+
+// SHA1Hash contains the SHA1 hash of a file or stream.
+type SHA1Hash [20]byte
+
+type streamDescriptor struct {
+	resourceDescriptor
+	PartNumber uint16
+	RefCount   uint32
+	Hash       SHA1Hash
+}
+
+type hdrFlag uint32
+
+//nolint:deadcode,varcheck // need unused variables for iota to work
+const (
+	hdrFlagReserved hdrFlag = 1 << iota
+	hdrFlagCompressed
+	hdrFlagReadOnly
+	hdrFlagSpanned
+	hdrFlagResourceOnly
+	hdrFlagMetadataOnly
+	hdrFlagWriteInProgress
+	hdrFlagRpFix
+)
+
+//nolint:deadcode,varcheck // need unused variables for iota to work
+const (
+	hdrFlagCompressReserved hdrFlag = 1 << (iota + 16)
+	hdrFlagCompressXpress
+	hdrFlagCompressLzx
+	hdrFlagCompressLzms
+)
+
+// versionSolid is the header version of WIM files that may contain solid
+// resources, such as ESD files.
+const versionSolid = 0xe00
+
+type wimHeader struct {
+	ImageTag        [8]byte
+	Size            uint32
+	Version         uint32
+	Flags           hdrFlag
+	CompressionSize uint32
+	WIMGuid         guid
+	PartNumber      uint16
+	TotalParts      uint16
+	ImageCount      uint32
+	OffsetTable     resourceDescriptor
+	XMLData         resourceDescriptor
+	BootMetadata    resourceDescriptor
+	BootIndex       uint32
+	Padding         uint32
+	Integrity       resourceDescriptor
+	Unused          [60]byte
+}
+
+// compression returns the format used by the non-solid compressed resources
+// of the WIM.
+func (h *wimHeader) compression() (compression, error) {
+	if h.Flags&hdrFlagCompressed == 0 {
+		return compressionNone, nil
+	}
+	switch {
+	case h.Flags&hdrFlagCompressLzx != 0:
+		return compressionLZX, nil
+	case h.Flags&hdrFlagCompressLzms != 0:
+		return compressionLZMS, nil
+	case h.Flags&hdrFlagCompressXpress != 0:
+		return compressionXpress, nil
+	}
+	return 0, fmt.Errorf("unsupported WIM flags %x", h.Flags)
+}
+
+type securityblockDisk struct {
+	TotalLength uint32
+	NumEntries  uint32
+}
+
+type direntry struct {
+	Attributes       uint32
+	SecurityID       uint32
+	SubdirOffset     int64
+	Unused1, Unused2 int64
+	CreationTime     Filetime
+	LastAccessTime   Filetime
+	LastWriteTime    Filetime
+	Hash             SHA1Hash
+	Padding          uint32
+	ReparseHardLink  int64
+	StreamCount      uint16
+	ShortNameLength  uint16
+	FileNameLength   uint16
+}
+
+var direntrySize = int64(binary.Size(direntry{}) + 8) // includes an 8-byte length prefix
+
+type streamentry struct {
+	Unused     int64
+	Hash       SHA1Hash
+	NameLength int16
+}
+
+var streamentrySize = int64(binary.Size(streamentry{}) + 8) // includes an 8-byte length prefix
+
+// Filetime represents a Windows time.
+type Filetime struct {
+	LowDateTime  uint32
+	HighDateTime uint32
+}
+
+// Time returns the time as time.Time.
+func (ft *Filetime) Time() time.Time {
+	// 100-nanosecond intervals since January 1, 1601
+	nsec := int64(ft.HighDateTime)<<32 + int64(ft.LowDateTime)
+	// change starting time to the Epoch (00:00:00 UTC, January 1, 1970)
+	nsec -= 116444736000000000
+	// convert into nanoseconds
+	nsec *= 100
+	return time.Unix(0, nsec)
+}
+
+// UnmarshalXML unmarshalls the time from a WIM XML blob.
+func (ft *Filetime) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	type Time struct {
+		Low  string `xml:"LOWPART"`
+		High string `xml:"HIGHPART"`
+	}
+	var t Time
+	err := d.DecodeElement(&t, &start)
+	if err != nil {
+		return err
+	}
+
+	low, err := strconv.ParseUint(t.Low, 0, 32)
+	if err != nil {
+		return err
+	}
+	high, err := strconv.ParseUint(t.High, 0, 32)
+	if err != nil {
+		return err
+	}
+
+	ft.LowDateTime = uint32(low)
+	ft.HighDateTime = uint32(high)
+	return nil
+}
+
+type info struct {
+	Image []ImageInfo `xml:"IMAGE"`
+}
+
+// ImageInfo contains information about the image.
+type ImageInfo struct {
+	Name         string       `xml:"NAME"`
+	Index        int          `xml:"INDEX,attr"`
+	CreationTime Filetime     `xml:"CREATIONTIME"`
+	ModTime      Filetime     `xml:"LASTMODIFICATIONTIME"`
+	Windows      *WindowsInfo `xml:"WINDOWS"`
+}
+
+// WindowsInfo contains information about the Windows installation in the image.
+type WindowsInfo struct {
+	Arch             byte     `xml:"ARCH"`
+	ProductName      string   `xml:"PRODUCTNAME"`
+	EditionID        string   `xml:"EDITIONID"`
+	InstallationType string   `xml:"INSTALLATIONTYPE"`
+	ProductType      string   `xml:"PRODUCTTYPE"`
+	Languages        []string `xml:"LANGUAGES>LANGUAGE"`
+	DefaultLanguage  string   `xml:"LANGUAGES>DEFAULT"`
+	Version          Version  `xml:"VERSION"`
+	SystemRoot       string   `xml:"SYSTEMROOT"`
+}
+
+// Version represents a Windows build version.
+type Version struct {
+	Major   int `xml:"MAJOR"`
+	Minor   int `xml:"MINOR"`
+	Build   int `xml:"BUILD"`
+	SPBuild int `xml:"SPBUILD"`
+	SPLevel int `xml:"SPLEVEL"`
+}
+
+// ParseError is returned when the WIM cannot be parsed.
+type ParseError struct {
+	Oper string
+	Path string
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	if e.Path == "" {
+		return "WIM parse error at " + e.Oper + ": " + e.Err.Error()
+	}
+	return fmt.Sprintf("WIM parse error: %s %s: %s", e.Oper, e.Path, e.Err.Error())
+}
+
+func (e *ParseError) Unwrap() error { return e.Err }
+
+// Reader provides functions to read a WIM file.
+type Reader struct {
+	hdr      wimHeader // header of the first part
+	r        io.ReaderAt
+	fileData map[SHA1Hash]blob
+	cache    chunkCache
+
+	XMLInfo string   // The XML information about the WIM.
+	Image   []*Image // The WIM's images.
+}
+
+// Image represents an image within a WIM file.
+type Image struct {
+	wim        *Reader
+	metadata   blob
+	rootOffset int64
+
+	ImageInfo
+}
+
+// StreamHeader contains alternate data stream metadata.
+type StreamHeader struct {
+	Name string
+	Hash SHA1Hash
+	Size int64
+}
+
+// Stream represents an alternate data stream or reparse point data stream.
+type Stream struct {
+	StreamHeader
+	data blob
+}
+
+// FileHeader contains file metadata.
+type FileHeader struct {
+	Name            string
+	ShortName       string
+	Attributes      uint32
+	CreationTime    Filetime
+	LastAccessTime  Filetime
+	LastWriteTime   Filetime
+	Hash            SHA1Hash
+	Size            int64
+	LinkID          int64
+	ReparseTag      uint32
+	ReparseReserved uint32
+}
+
+// File represents a file or directory in a WIM image.
+type File struct {
+	FileHeader
+	Streams      []*Stream
+	data         blob
+	img          *Image
+	subdirOffset int64
+}
+
+// part is one file of a WIM. Unsplit WIMs consist of a single part.
+type part struct {
+	r   io.ReaderAt
+	hdr wimHeader
+}
+
+func readPart(f io.ReaderAt) (*part, error) {
+	p := &part{r: f}
+	section := io.NewSectionReader(f, 0, 0xffff)
+	err := binary.Read(section, binary.LittleEndian, &p.hdr)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.hdr.ImageTag != wimImageTag {
+		return nil, &ParseError{Oper: "image tag", Err: errors.New("not a WIM file")}
+	}
+
+	comp, err := p.hdr.compression()
+	if err != nil {
+		return nil, err
+	}
+
+	// Compressed resources are split into chunks of CompressionSize bytes,
+	// so it cannot be zero when the WIM is compressed.
+	if p.hdr.CompressionSize&(p.hdr.CompressionSize-1) != 0 || p.hdr.CompressionSize > 1<<26 ||
+		comp != compressionNone && p.hdr.CompressionSize == 0 {
+		return nil, fmt.Errorf("unsupported compression size %d", p.hdr.CompressionSize)
+	}
+
+	if p.hdr.PartNumber == 0 || p.hdr.PartNumber > p.hdr.TotalParts {
+		return nil, &ParseError{Oper: "header", Err: fmt.Errorf("invalid part number %d of %d", p.hdr.PartNumber, p.hdr.TotalParts)}
+	}
+	return p, nil
+}
+
+// ErrSplitWIM is returned by NewReader for a part of a split WIM, which must be
+// opened together with the other parts using NewSplitReader.
+var ErrSplitWIM = errors.New("WIM is split into several parts")
+
+// NewReader returns a Reader that can be used to read WIM file data.
+func NewReader(f io.ReaderAt) (*Reader, error) {
+	p, err := readPart(f)
+	if err != nil {
+		return nil, err
+	}
+	if p.hdr.TotalParts != 1 {
+		return nil, ErrSplitWIM
+	}
+	return newReader([]*part{p})
+}
+
+// NewSplitReader returns a Reader for a WIM split into several files, such as
+// install.swm, install2.swm and so on. The parts may be given in any order, but
+// all of them must be present. File data is read from whichever part holds it.
+func NewSplitReader(files ...io.ReaderAt) (*Reader, error) {
+	if len(files) == 0 {
+		return nil, errors.New("no WIM parts given")
+	}
+	var parts []*part
+	for _, f := range files {
+		p, err := readPart(f)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, p)
+	}
+	slices.SortFunc(parts, func(a, b *part) int {
+		return cmp.Compare(a.hdr.PartNumber, b.hdr.PartNumber)
+	})
+
+	first := &parts[0].hdr
+	if int(first.TotalParts) != len(parts) {
+		return nil, fmt.Errorf("split WIM has %d parts, but %d were given", first.TotalParts, len(parts))
+	}
+	for i, p := range parts {
+		if p.hdr.WIMGuid != first.WIMGuid {
+			return nil, fmt.Errorf("part %d belongs to WIM %s, not %s", p.hdr.PartNumber, p.hdr.WIMGuid, first.WIMGuid)
+		}
+		if p.hdr.TotalParts != first.TotalParts {
+			return nil, fmt.Errorf("part %d has mismatched part count %d", p.hdr.PartNumber, p.hdr.TotalParts)
+		}
+		if int(p.hdr.PartNumber) != i+1 {
+			return nil, fmt.Errorf("split WIM part %d is missing or given twice", i+1)
+		}
+	}
+	return newReader(parts)
+}
+
+// newReader reads the blob tables of all parts, and the images and XML data
+// of the first one.
+func newReader(parts []*part) (*Reader, error) {
+	r := &Reader{
+		hdr:      parts[0].hdr,
+		r:        parts[0].r,
+		fileData: make(map[SHA1Hash]blob),
+	}
+
+	var images []*Image
+	metadata := make(map[blobLocation]bool)
+	for _, p := range parts {
+		partImages, err := r.readOffsetTable(p, parts, metadata)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, partImages...)
+	}
+	if len(images) != int(r.hdr.ImageCount) {
+		return nil, &ParseError{Oper: "offset table", Err: errors.New("mismatched image count")}
+	}
+
+	xmlinfo, err := r.readXML(parts[0])
+	if err != nil {
+		return nil, err
+	}
+
+	var inf info
+	err = xml.Unmarshal([]byte(xmlinfo), &inf)
+	if err != nil {
+		return nil, &ParseError{Oper: "XML info", Err: err}
+	}
+
+	for i, img := range images {
+		for _, imgInfo := range inf.Image {
+			if imgInfo.Index == i+1 {
+				img.ImageInfo = imgInfo
+				break
+			}
+		}
+	}
+
+	r.Image = images
+	r.XMLInfo = xmlinfo
+	return r, nil
+}
+
+// Close releases resources associated with the Reader.
+func (r *Reader) Close() error {
+	r.cache.reset()
+	return nil
+}
+
+// newResource describes the non-solid resource hdr of part p.
+func (r *Reader) newResource(p *part, hdr *resourceDescriptor) *resource {
+	res := &resource{
+		wim:         r,
+		r:           p.r,
+		offset:      hdr.Offset,
+		storedSize:  hdr.CompressedSize(),
+		size:        hdr.OriginalSize,
+		compression: compressionNone,
+		chunkSize:   int64(p.hdr.CompressionSize),
+	}
+	if hdr.Flags()&resFlagCompressed != 0 {
+		// readPart has already validated the header flags.
+		res.compression, _ = p.hdr.compression()
+	}
+	return res
+}
+
+// newSolidResource describes the solid resource hdr of part p, whose size and
+// compression are read from the header at its start.
+func (r *Reader) newSolidResource(p *part, hdr *resourceDescriptor) (*resource, error) {
+	var sh solidHeader
+	section := io.NewSectionReader(p.r, hdr.Offset, solidHeaderSize)
+	if err := binary.Read(section, binary.LittleEndian, &sh); err != nil {
+		return nil, &ParseError{Oper: "solid resource header", Err: err}
+	}
+	if sh.ChunkSize == 0 || sh.ChunkSize&(sh.ChunkSize-1) != 0 || sh.ChunkSize > 1<<30 || sh.OriginalSize < 0 {
+		return nil, &ParseError{Oper: "solid resource header", Err: fmt.Errorf("invalid chunk size %d", sh.ChunkSize)}
+	}
+	return &resource{
+		wim:         r,
+		r:           p.r,
+		offset:      hdr.Offset,
+		storedSize:  hdr.CompressedSize(),
+		size:        sh.OriginalSize,
+		compression: sh.Compression,
+		chunkSize:   int64(sh.ChunkSize),
+		solid:       true,
+	}, nil
+}
+
+func (r *Reader) readResource(p *part, hdr *resourceDescriptor) ([]byte, error) {
+	if hdr.OriginalSize < 0 {
+		return nil, &ParseError{Oper: "resource", Err: fmt.Errorf("invalid size %d", hdr.OriginalSize)}
+	}
+	data := make([]byte, hdr.OriginalSize)
+	_, err := r.newResource(p, hdr).ReadAt(data, 0)
+	if err == io.EOF { //nolint:errorlint
+		err = nil
+	}
+	return data, err
+}
+
+func (r *Reader) readXML(p *part) (string, error) {
+	if p.hdr.XMLData.CompressedSize() == 0 {
+		return "", nil
+	}
+	data, err := r.readResource(p, &p.hdr.XMLData)
+	if err != nil {
+		return "", &ParseError{Oper: "XML data", Err: err}
+	}
+
+	xmlData := make([]uint16, len(data)/2)
+	err = binary.Read(bytes.NewReader(data), binary.LittleEndian, xmlData)
+	if err != nil {
+		return "", &ParseError{Oper: "XML data", Err: err}
+	}
+
+	// The BOM will always indicate little-endian UTF-16.
+	if len(xmlData) == 0 || xmlData[0] != 0xfeff {
+		return "", &ParseError{Oper: "XML data", Err: errors.New("invalid BOM")}
+	}
+	return string(utf16.Decode(xmlData[1:])), nil
+}
+
+// blobLocation is where a resource is stored in a split WIM.
+type blobLocation struct {
+	part   uint16
+	offset int64
+}
+
+// readOffsetTable adds the blobs listed in the blob table of part p to
+// r.fileData and returns the images whose metadata it lists. The blob table of
+// a split WIM part may also list blobs stored in other parts, which are read
+// from the part they name; metadata records the metadata resources already
+// seen, so that an image listed by several parts is returned once.
+func (r *Reader) readOffsetTable(p *part, parts []*part, metadata map[blobLocation]bool) ([]*Image, error) {
+	var images []*Image
+
+	offsetTable, err := r.readResource(p, &p.hdr.OffsetTable)
+	if err != nil {
+		return nil, &ParseError{Oper: "offset table", Err: err}
+	}
+
+	var entries []streamDescriptor
+	br := bytes.NewReader(offsetTable)
+	for {
+		var entry streamDescriptor
+		err := binary.Read(br, binary.LittleEndian, &entry)
+		if err == io.EOF { //nolint:errorlint
+			break
+		}
+		if err != nil {
+			return nil, &ParseError{Oper: "offset table", Err: err}
+		}
+		if p.hdr.Version != versionSolid {
+			// The solid flag has no meaning in older WIM versions.
+			entry.FlagsAndCompressedSize &^= uint64(resFlagSolid) << 56
+		}
+		entries = append(entries, entry)
+	}
+
+	// Consecutive solid entries form a run. Each run describes one or more
+	// solid resources, and the blobs in the run are located by their
+	// offset into the concatenated uncompressed data of those resources.
+	var run []*resource
+	for i := range entries {
+		entry := &entries[i]
+
+		if entry.Flags()&resFlagSolid == 0 {
+			run = nil
+			if entry.PartNumber == 0 || int(entry.PartNumber) > len(parts) {
+				return nil, &ParseError{Oper: "offset table", Err: fmt.Errorf("blob stored in missing part %d", entry.PartNumber)}
+			}
+			if entry.Flags()&resFlagSpanned != 0 {
+				return nil, &ParseError{Oper: "offset table", Err: errors.New("resources spanning several parts are not supported")}
+			}
+			// newReader gives the parts in order of their numbers.
+			res := r.newResource(parts[entry.PartNumber-1], &entry.resourceDescriptor)
+			if entry.Flags()&resFlagMetadata != 0 {
+				key := blobLocation{entry.PartNumber, entry.Offset}
+				if metadata[key] {
+					continue
+				}
+				metadata[key] = true
+				images = append(images, &Image{
+					wim:      r,
+					metadata: blob{res: res, size: res.size},
+				})
+			} else {
+				r.fileData[entry.Hash] = blob{res: res, size: res.size}
+			}
+			continue
+		}
+
+		// Solid resources are only read from the part whose table
+		// lists them.
+		if entry.PartNumber != p.hdr.PartNumber {
+			continue
+		}
+		if run == nil {
+			for j := i; j < len(entries) && entries[j].Flags()&resFlagSolid != 0; j++ {
+				if entries[j].OriginalSize != solidResourceMagic || entries[j].PartNumber != p.hdr.PartNumber {
+					continue
+				}
+				res, err := r.newSolidResource(p, &entries[j].resourceDescriptor)
+				if err != nil {
+					return nil, err
+				}
+				run = append(run, res)
+			}
+		}
+		if entry.OriginalSize == solidResourceMagic {
+			continue
+		}
+
+		b, err := locateSolidBlob(run, entry)
+		if err != nil {
+			return nil, err
+		}
+		r.fileData[entry.Hash] = b
+	}
+
+	return images, nil
+}
+
+// locateSolidBlob finds the solid resource of run holding the blob described
+// by entry, whose offset and compressed size fields give its position and
+// size within the run.
+func locateSolidBlob(run []*resource, entry *streamDescriptor) (blob, error) {
+	offset := entry.Offset
+	size := entry.CompressedSize()
+	for _, res := range run {
+		if offset+size <= res.size {
+			return blob{res: res, offset: offset, size: size}, nil
+		}
+		offset -= res.size
+	}
+	return blob{}, &ParseError{Oper: "offset table", Err: errors.New("blob could not be assigned to a solid resource")}
+}
+
+// Open parses the image and returns the root directory.
+func (img *Image) Open() (*File, error) {
+	if img.rootOffset == 0 {
+		var secBlock securityblockDisk
+		rsrc := img.metadata.open()
+		err := binary.Read(rsrc, binary.LittleEndian, &secBlock)
+		rsrc.Close()
+		if err != nil {
+			return nil, &ParseError{Oper: "security table", Err: err}
+		}
+		if secBlock.TotalLength < 8 {
+			// An empty security table only holds its own header.
+			secBlock.TotalLength = 8
+		}
+		img.rootOffset = int64((secBlock.TotalLength + 7) &^ 7)
+	}
+
+	f, err := img.readdir(img.rootOffset)
+	if err != nil {
+		return nil, err
+	}
+	if len(f) != 1 {
+		return nil, &ParseError{Oper: "root directory", Err: errors.New("expected exactly 1 root directory entry")}
+	}
+	return f[0], err
+}
+
+func (img *Image) readdir(offset int64) ([]*File, error) {
+	if offset < 0 || offset >= img.metadata.size {
+		return nil, &ParseError{Oper: "directory", Err: errors.New("offset out of bounds")}
+	}
+	r := bufio.NewReader(io.NewSectionReader(img.metadata.res, img.metadata.offset+offset, img.metadata.size-offset))
+
+	var entries []*File
+	for {
+		e, err := img.readNextEntry(r)
+		if err == io.EOF { //nolint:errorlint
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func (img *Image) readNextEntry(r io.Reader) (*File, error) {
+	var length int64
+	err := binary.Read(r, binary.LittleEndian, &length)
+	if err != nil {
+		return nil, &ParseError{Oper: "directory length check", Err: err}
+	}
+
+	if length == 0 {
+		return nil, io.EOF
+	}
+
+	left := length
+	if left < direntrySize {
+		return nil, &ParseError{Oper: "directory entry", Err: errors.New("size too short")}
+	}
+
+	var dentry direntry
+	err = binary.Read(r, binary.LittleEndian, &dentry)
+	if err != nil {
+		return nil, &ParseError{Oper: "directory entry", Err: err}
+	}
+
+	left -= direntrySize
+
+	namesLen := int64(dentry.FileNameLength + 2 + dentry.ShortNameLength)
+	if left < namesLen {
+		return nil, &ParseError{Oper: "directory entry", Err: errors.New("size too short for names")}
+	}
+
+	names := make([]uint16, namesLen/2)
+	err = binary.Read(r, binary.LittleEndian, names)
+	if err != nil {
+		return nil, &ParseError{Oper: "file name", Err: err}
+	}
+
+	left -= namesLen
+
+	var name, shortName string
+	if dentry.FileNameLength > 0 {
+		name = string(utf16.Decode(names[:dentry.FileNameLength/2]))
+	}
+
+	if dentry.ShortNameLength > 0 {
+		shortName = string(utf16.Decode(names[dentry.FileNameLength/2+1:]))
+	}
+
+	var data blob
+	if dentry.Hash != (SHA1Hash{}) {
+		var ok bool
+		data, ok = img.wim.fileData[dentry.Hash]
+		if !ok {
+			return nil, &ParseError{
+				Oper: "directory entry",
+				Path: name,
+				Err:  fmt.Errorf("could not find file data matching hash %x", dentry.Hash),
+			}
+		}
+	}
+
+	f := &File{
+		FileHeader: FileHeader{
+			Attributes:     dentry.Attributes,
+			CreationTime:   dentry.CreationTime,
+			LastAccessTime: dentry.LastAccessTime,
+			LastWriteTime:  dentry.LastWriteTime,
+			Hash:           dentry.Hash,
+			Size:           data.size,
+			Name:           name,
+			ShortName:      shortName,
+		},
+
+		data:         data,
+		img:          img,
+		subdirOffset: dentry.SubdirOffset,
+	}
+
+	isDir := false
+
+	if dentry.Attributes&FILE_ATTRIBUTE_REPARSE_POINT == 0 {
+		f.LinkID = dentry.ReparseHardLink
+		if dentry.Attributes&FILE_ATTRIBUTE_DIRECTORY != 0 {
+			isDir = true
+		}
+	} else {
+		f.ReparseTag = uint32(dentry.ReparseHardLink)
+		f.ReparseReserved = uint32(dentry.ReparseHardLink >> 32)
+	}
+
+	if isDir && f.subdirOffset == 0 {
+		return nil, &ParseError{Oper: "directory entry", Path: name, Err: errors.New("no subdirectory data for directory")}
+	} else if !isDir && f.subdirOffset != 0 {
+		return nil, &ParseError{Oper: "directory entry", Path: name, Err: errors.New("unexpected subdirectory data for non-directory")}
+	}
+
+	_, err = io.CopyN(io.Discard, r, left)
+	if err != nil {
+		if err == io.EOF { //nolint:errorlint
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	if dentry.StreamCount > 0 {
+		var streams []*Stream
+		for i := uint16(0); i < dentry.StreamCount; i++ {
+			s, err := img.readNextStream(r)
+			if err != nil {
+				return nil, err
+			}
+			// The first unnamed stream should be treated as the file stream.
+			if i == 0 && s.Name == "" {
+				f.Hash = s.Hash
+				f.Size = s.Size
+				f.data = s.data
+			} else if s.Name != "" {
+				streams = append(streams, s)
+			}
+		}
+		f.Streams = streams
+	}
+
+	if dentry.Attributes&FILE_ATTRIBUTE_REPARSE_POINT != 0 && f.Size == 0 {
+		return nil, &ParseError{
+			Oper: "directory entry",
+			Path: name,
+			Err:  errors.New("reparse point is missing reparse stream"),
+		}
+	}
+
+	return f, nil
+}
+
+func (img *Image) readNextStream(r io.Reader) (*Stream, error) {
+	var length int64
+	err := binary.Read(r, binary.LittleEndian, &length)
+	if err != nil {
+		if err == io.EOF { //nolint:errorlint
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, &ParseError{Oper: "stream length check", Err: err}
+	}
+
+	left := length
+	if left < streamentrySize {
+		return nil, &ParseError{Oper: "stream entry", Err: errors.New("size too short")}
+	}
+
+	var sentry streamentry
+	err = binary.Read(r, binary.LittleEndian, &sentry)
+	if err != nil {
+		return nil, &ParseError{Oper: "stream entry", Err: err}
+	}
+
+	left -= streamentrySize
+
+	if left < int64(sentry.NameLength) {
+		return nil, &ParseError{Oper: "stream entry", Err: errors.New("size too short for name")}
+	}
+
+	names := make([]uint16, sentry.NameLength/2)
+	err = binary.Read(r, binary.LittleEndian, names)
+	if err != nil {
+		return nil, &ParseError{Oper: "file name", Err: err}
+	}
+
+	left -= int64(sentry.NameLength)
+	name := string(utf16.Decode(names))
+
+	var data blob
+	if sentry.Hash != (SHA1Hash{}) {
+		var ok bool
+		data, ok = img.wim.fileData[sentry.Hash]
+		if !ok {
+			return nil, &ParseError{
+				Oper: "stream entry",
+				Path: name,
+				Err:  fmt.Errorf("could not find file data matching hash %x", sentry.Hash),
+			}
+		}
+	}
+
+	s := &Stream{
+		StreamHeader: StreamHeader{
+			Hash: sentry.Hash,
+			Size: data.size,
+			Name: name,
+		},
+		data: data,
+	}
+
+	_, err = io.CopyN(io.Discard, r, left)
+	if err != nil {
+		if err == io.EOF { //nolint:errorlint
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Open returns an io.ReadCloser that can be used to read the stream's contents.
+func (s *Stream) Open() (io.ReadCloser, error) {
+	return s.data.open(), nil
+}
+
+// Open returns an io.ReadCloser that can be used to read the file's contents.
+func (f *File) Open() (io.ReadCloser, error) {
+	return f.data.open(), nil
+}
+
+// Readdir reads the directory entries.
+func (f *File) Readdir() ([]*File, error) {
+	if !f.IsDir() {
+		return nil, errors.New("not a directory")
+	}
+	return f.img.readdir(f.subdirOffset)
+}
+
+// IsDir returns whether the given file is a directory. It returns false when it
+// is a directory reparse point.
+func (f *FileHeader) IsDir() bool {
+	return f.Attributes&(FILE_ATTRIBUTE_DIRECTORY|FILE_ATTRIBUTE_REPARSE_POINT) == FILE_ATTRIBUTE_DIRECTORY
+}
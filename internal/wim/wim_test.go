@@ -0,0 +1,308 @@
+package wim
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+// testFile is a file in the root directory of the image of a test WIM. Its
+// data is stored in part, and the blob tables of the parts in listedIn
+// describe it; by default only part does.
+type testFile struct {
+	name     string
+	data     string
+	part     int
+	listedIn []int
+}
+
+var testGUID = guid{Data1: 0x12345678}
+
+// dirEntry encodes a directory entry of image metadata.
+func dirEntry(attributes uint32, subdirOffset int64, hash SHA1Hash, name string) []byte {
+	names := utf16.Encode([]rune(name))
+	de := direntry{
+		Attributes:     attributes,
+		SubdirOffset:   subdirOffset,
+		Hash:           hash,
+		FileNameLength: uint16(2 * len(names)),
+	}
+	length := (direntrySize + int64(2*len(names)) + 2 + 7) &^ 7
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, length)
+	binary.Write(&buf, binary.LittleEndian, &de)
+	binary.Write(&buf, binary.LittleEndian, names)
+	buf.Write(make([]byte, length-int64(buf.Len())))
+	return buf.Bytes()
+}
+
+// imageMetadata encodes the metadata of an image holding files in its root
+// directory.
+func imageMetadata(files []testFile) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, securityblockDisk{TotalLength: 8})
+	root := dirEntry(FILE_ATTRIBUTE_DIRECTORY, 0, SHA1Hash{}, "")
+	subdir := int64(buf.Len() + len(root) + 8)
+	buf.Write(dirEntry(FILE_ATTRIBUTE_DIRECTORY, subdir, SHA1Hash{}, ""))
+	buf.Write(make([]byte, 8))
+	for _, f := range files {
+		buf.Write(dirEntry(FILE_ATTRIBUTE_NORMAL, 0, sha1.Sum([]byte(f.data)), f.name))
+	}
+	buf.Write(make([]byte, 8))
+	return buf.Bytes()
+}
+
+// buildSplitWIM returns the parts of an uncompressed WIM split into total
+// parts, whose single image holds files. The metadata and XML data are in the
+// first part.
+func buildSplitWIM(total int, guids map[int]guid, files []testFile) [][]byte {
+	type blob struct {
+		flags    resFlag
+		data     []byte
+		part     int
+		listedIn []int
+		offset   int64
+	}
+	blobs := []*blob{{flags: resFlagMetadata, data: imageMetadata(files), part: 1}}
+	for _, f := range files {
+		blobs = append(blobs, &blob{data: []byte(f.data), part: f.part, listedIn: f.listedIn})
+	}
+
+	xmlData := []uint16{0xfeff}
+	xmlData = append(xmlData, utf16.Encode([]rune(`<WIM><IMAGE INDEX="1"><NAME>Test</NAME></IMAGE></WIM>`))...)
+
+	hdrSize := int64(binary.Size(wimHeader{}))
+	var parts [][]byte
+	for n := 1; n <= total; n++ {
+		var body bytes.Buffer
+		for _, b := range blobs {
+			if b.part == n {
+				b.offset = hdrSize + int64(body.Len())
+				body.Write(b.data)
+			}
+		}
+		parts = append(parts, body.Bytes())
+	}
+
+	for n := 1; n <= total; n++ {
+		var table bytes.Buffer
+		for _, b := range blobs {
+			listed := b.listedIn
+			if listed == nil {
+				listed = []int{b.part}
+			}
+			for _, l := range listed {
+				if l != n {
+					continue
+				}
+				binary.Write(&table, binary.LittleEndian, &streamDescriptor{
+					resourceDescriptor: resourceDescriptor{
+						FlagsAndCompressedSize: uint64(b.flags)<<56 | uint64(len(b.data)),
+						Offset:                 b.offset,
+						OriginalSize:           int64(len(b.data)),
+					},
+					PartNumber: uint16(b.part),
+					RefCount:   1,
+					Hash:       sha1.Sum(b.data),
+				})
+			}
+		}
+
+		g, ok := guids[n]
+		if !ok {
+			g = testGUID
+		}
+		hdr := wimHeader{
+			ImageTag:   wimImageTag,
+			Size:       uint32(hdrSize),
+			Version:    0x10d00,
+			Flags:      hdrFlagSpanned,
+			WIMGuid:    g,
+			PartNumber: uint16(n),
+			TotalParts: uint16(total),
+			ImageCount: 1,
+		}
+		body := parts[n-1]
+		offset := hdrSize + int64(len(body))
+		hdr.OffsetTable = resourceDescriptor{uint64(table.Len()), offset, int64(table.Len())}
+		offset += int64(table.Len())
+		if n == 1 {
+			hdr.XMLData = resourceDescriptor{uint64(2 * len(xmlData)), offset, int64(2 * len(xmlData))}
+		}
+
+		var buf bytes.Buffer
+		binary.Write(&buf, binary.LittleEndian, &hdr)
+		buf.Write(body)
+		buf.Write(table.Bytes())
+		if n == 1 {
+			binary.Write(&buf, binary.LittleEndian, xmlData)
+		}
+		parts[n-1] = buf.Bytes()
+	}
+	return parts
+}
+
+func readerAts(parts [][]byte, order ...int) []io.ReaderAt {
+	var ras []io.ReaderAt
+	for _, i := range order {
+		ras = append(ras, bytes.NewReader(parts[i-1]))
+	}
+	return ras
+}
+
+// readFiles returns the contents of the files in the root of the only image
+// of r, by name.
+func readFiles(t *testing.T, r *Reader) map[string]string {
+	t.Helper()
+	if len(r.Image) != 1 {
+		t.Fatalf("WIM has %d images, want 1", len(r.Image))
+	}
+	root, err := r.Image[0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := root.Readdir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	for _, e := range entries {
+		rc, err := e.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", e.Name, err)
+		}
+		files[e.Name] = string(data)
+	}
+	return files
+}
+
+func checkFiles(t *testing.T, r *Reader, files []testFile) {
+	t.Helper()
+	got := readFiles(t, r)
+	if len(got) != len(files) {
+		t.Errorf("image has %d files, want %d", len(got), len(files))
+	}
+	for _, f := range files {
+		if got[f.name] != f.data {
+			t.Errorf("%s has data %q, want %q", f.name, got[f.name], f.data)
+		}
+	}
+}
+
+var splitFiles = []testFile{
+	{name: "arial.ttf", data: "first part", part: 1},
+	{name: "times.ttf", data: "second part", part: 2},
+	{name: "cour.ttf", data: "third part", part: 3},
+}
+
+func TestSplitReaderOutOfOrder(t *testing.T) {
+	parts := buildSplitWIM(3, nil, splitFiles)
+	r, err := NewSplitReader(readerAts(parts, 3, 1, 2)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Image[0].Name != "Test" {
+		t.Errorf("image is named %q, want Test", r.Image[0].Name)
+	}
+	checkFiles(t, r, splitFiles)
+}
+
+func TestSplitReaderMissingPart(t *testing.T) {
+	parts := buildSplitWIM(3, nil, splitFiles)
+	if _, err := NewSplitReader(readerAts(parts, 1, 3)...); err == nil {
+		t.Error("no error for a missing part")
+	}
+	if _, err := NewSplitReader(readerAts(parts, 1, 3, 3)...); err == nil {
+		t.Error("no error for a part given twice")
+	}
+}
+
+func TestSplitReaderMismatchedGUID(t *testing.T) {
+	parts := buildSplitWIM(3, map[int]guid{2: {Data1: 0xdeadbeef}}, splitFiles)
+	_, err := NewSplitReader(readerAts(parts, 1, 2, 3)...)
+	if err == nil || !strings.Contains(err.Error(), "belongs to WIM") {
+		t.Errorf("got error %v, want a GUID mismatch", err)
+	}
+}
+
+func TestSplitReaderBlobListedInOtherPart(t *testing.T) {
+	files := []testFile{
+		{name: "arial.ttf", data: "first part", part: 1},
+		// Described only by the blob table of the first part.
+		{name: "times.ttf", data: "second part", part: 2, listedIn: []int{1}},
+		// Described by the blob tables of every part.
+		{name: "cour.ttf", data: "third part", part: 3, listedIn: []int{1, 2, 3}},
+	}
+	parts := buildSplitWIM(3, nil, files)
+	r, err := NewSplitReader(readerAts(parts, 2, 3, 1)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(t, r, files)
+}
+
+func TestNewReaderRejectsSplitPart(t *testing.T) {
+	parts := buildSplitWIM(2, nil, splitFiles[:2])
+	if _, err := NewReader(bytes.NewReader(parts[0])); err != ErrSplitWIM {
+		t.Errorf("got error %v, want ErrSplitWIM", err)
+	}
+
+	whole := buildSplitWIM(1, nil, splitFiles[:1])
+	r, err := NewReader(bytes.NewReader(whole[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(t, r, splitFiles[:1])
+}
+
+// withHeader returns a copy of the WIM data with its header changed by edit.
+func withHeader(data []byte, edit func(*wimHeader)) []byte {
+	var hdr wimHeader
+	binary.Read(bytes.NewReader(data), binary.LittleEndian, &hdr)
+	edit(&hdr)
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, &hdr)
+	return append(buf.Bytes(), data[buf.Len():]...)
+}
+
+func TestNewReaderCorruptHeader(t *testing.T) {
+	whole := buildSplitWIM(1, nil, splitFiles[:1])[0]
+	compressed := hdrFlagCompressed | hdrFlagCompressXpress
+	for _, tt := range []struct {
+		name string
+		edit func(*wimHeader)
+	}{
+		{"image tag", func(h *wimHeader) { h.ImageTag[0] = 'X' }},
+		{"unknown compression", func(h *wimHeader) { h.Flags |= hdrFlagCompressed }},
+		{"compressed without chunk size", func(h *wimHeader) {
+			h.Flags |= compressed
+			h.CompressionSize = 0
+		}},
+		{"chunk size not a power of two", func(h *wimHeader) {
+			h.Flags |= compressed
+			h.CompressionSize = 3000
+		}},
+		{"chunk size too large", func(h *wimHeader) {
+			h.Flags |= compressed
+			h.CompressionSize = 1 << 27
+		}},
+		{"part number zero", func(h *wimHeader) { h.PartNumber = 0 }},
+		{"part number past the total", func(h *wimHeader) { h.PartNumber = 2 }},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewReader(bytes.NewReader(withHeader(whole, tt.edit))); err == nil {
+				t.Error("no error")
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+// Package xpress implements a decompressor for the XPRESS Huffman format, as
+// used by WIM files captured with /compress:fast.
+//
+// The format is described in section 2 of [MS-XCA]. Each WIM chunk is a
+// single block: a table of Huffman codeword lengths followed by the bitstream,
+// with no end of data symbol.
+package xpress
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	numSymbols     = 512
+	tableSize      = numSymbols / 2
+	maxCodewordLen = 15
+	maxBlockSize   = 65536
+)
+
+var errCorrupt = errors.New("XPRESS data corrupt")
+
+// Decompressor decompresses XPRESS Huffman chunks. It keeps its decoding
+// table between calls so that decompressing many chunks does not allocate.
+type Decompressor struct {
+	// table maps the next maxCodewordLen bits to sym<<4 | len.
+	table [1 << maxCodewordLen]uint16
+}
+
+// NewDecompressor returns a Decompressor ready for use.
+func NewDecompressor() *Decompressor {
+	return &Decompressor{}
+}
+
+// Decompress decompresses the XPRESS chunk in into out, whose length must be
+// the exact uncompressed size of the chunk.
+func Decompress(in, out []byte) error {
+	return NewDecompressor().Decompress(in, out)
+}
+
+func (d *Decompressor) buildTable(lens []byte) error {
+	var count [maxCodewordLen + 1]int
+	for sym := range numSymbols {
+		count[symbolLen(lens, sym)]++
+	}
+	count[0] = 0
+
+	var next [maxCodewordLen + 1]uint32
+	for l := 2; l <= maxCodewordLen; l++ {
+		next[l] = (next[l-1] + uint32(count[l-1])) << 1
+	}
+	// Every codeword must fit in the table; a complete code fills it exactly.
+	if next[maxCodewordLen]+uint32(count[maxCodewordLen]) > 1<<maxCodewordLen {
+		return errCorrupt
+	}
+
+	clear(d.table[:])
+	for sym := range numSymbols {
+		l := symbolLen(lens, sym)
+		if l == 0 {
+			continue
+		}
+		codeword := next[l]
+		next[l]++
+		start := codeword << (maxCodewordLen - l)
+		end := (codeword + 1) << (maxCodewordLen - l)
+		entry := uint16(sym)<<4 | uint16(l)
+		for j := start; j < end; j++ {
+			d.table[j] = entry
+		}
+	}
+	return nil
+}
+
+// symbolLen returns the codeword length of sym: the table packs two lengths per
+// byte, the even symbol in the low nibble.
+func symbolLen(lens []byte, sym int) uint {
+	return uint(lens[sym/2]>>(4*(sym&1))) & 0xf
+}
+
+// Decompress decompresses the XPRESS chunk in into out, whose length must be
+// the exact uncompressed size of the chunk.
+func (d *Decompressor) Decompress(in, out []byte) error {
+	if len(out) > maxBlockSize {
+		return errors.New("XPRESS chunk too large")
+	}
+	if len(in) < tableSize+4 {
+		return errCorrupt
+	}
+	if err := d.buildTable(in[:tableSize]); err != nil {
+		return err
+	}
+
+	pos := tableSize
+	// read16 returns the next 16-bit word of the input, or zero past its
+	// end, which is how the bitstream is padded.
+	read16 := func() uint32 {
+		if pos+2 > len(in) {
+			pos += 2
+			return 0
+		}
+		v := uint32(binary.LittleEndian.Uint16(in[pos:]))
+		pos += 2
+		return v
+	}
+
+	nextBits := read16()<<16 | read16()
+	extraBits := 16
+	consume := func(n int) {
+		nextBits <<= n
+		extraBits -= n
+		if extraBits < 0 {
+			nextBits |= read16() << -extraBits
+			extraBits += 16
+		}
+	}
+
+	outPos := 0
+	for outPos < len(out) {
+		entry := d.table[nextBits>>(32-maxCodewordLen)]
+		if entry == 0 {
+			return errCorrupt
+		}
+		consume(int(entry & 0xf))
+		sym := int(entry >> 4)
+
+		if sym < 256 {
+			out[outPos] = byte(sym)
+			outPos++
+			continue
+		}
+
+		sym -= 256
+		length := sym & 0xf
+		offsetBits := sym >> 4
+		if length == 15 {
+			if pos >= len(in) {
+				return errCorrupt
+			}
+			length = int(in[pos])
+			pos++
+			if length == 255 {
+				if pos+2 > len(in) {
+					return errCorrupt
+				}
+				length = int(binary.LittleEndian.Uint16(in[pos:]))
+				pos += 2
+				if length < 15 {
+					return errCorrupt
+				}
+				length -= 15
+			}
+			length += 15
+		}
+		length += 3
+
+		offset := 1 << offsetBits
+		if offsetBits > 0 {
+			offset += int(nextBits >> (32 - offsetBits))
+			consume(offsetBits)
+		}
+
+		if offset > outPos || length > len(out)-outPos {
+			return errCorrupt
+		}
+		src := outPos - offset
+		for range length {
+			out[outPos] = out[src]
+			outPos++
+			src++
+		}
+	}
+	return nil
+}
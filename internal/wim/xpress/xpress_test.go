@@ -0,0 +1,197 @@
+package xpress
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// testEncoder writes XPRESS Huffman chunks the way the reference compressor
+// lays them out, with every symbol given a 9-bit codeword so that the code of
+// a symbol is the symbol itself. Bits are written in 16-bit words, the words
+// being reserved two ahead of the extra length bytes, which are written where
+// the decompressor will find them.
+type testEncoder struct {
+	out       []byte
+	bitbuf    uint64
+	bitcount  uint
+	nextBits  int
+	nextBits2 int
+}
+
+func newTestEncoder() *testEncoder {
+	e := &testEncoder{out: bytes.Repeat([]byte{0x99}, tableSize)}
+	e.nextBits = len(e.out)
+	e.nextBits2 = len(e.out) + 2
+	e.out = append(e.out, 0, 0, 0, 0)
+	return e
+}
+
+func (e *testEncoder) writeBits(bits uint64, n uint) {
+	e.bitbuf = e.bitbuf<<n | bits
+	e.bitcount += n
+	if e.bitcount > 16 {
+		e.bitcount -= 16
+		binary.LittleEndian.PutUint16(e.out[e.nextBits:], uint16(e.bitbuf>>e.bitcount))
+		e.nextBits = e.nextBits2
+		e.nextBits2 = len(e.out)
+		e.out = append(e.out, 0, 0)
+	}
+}
+
+func (e *testEncoder) literals(s string) {
+	for _, c := range []byte(s) {
+		e.writeBits(uint64(c), 9)
+	}
+}
+
+func (e *testEncoder) match(offset, length int) {
+	offsetBits := 0
+	for 1<<(offsetBits+1) <= offset {
+		offsetBits++
+	}
+	header := min(length-3, 15)
+	e.writeBits(uint64(256+(offsetBits<<4|header)), 9)
+	if header == 15 {
+		if length-3-15 < 255 {
+			e.out = append(e.out, byte(length-3-15))
+		} else {
+			e.out = append(e.out, 255)
+			e.out = binary.LittleEndian.AppendUint16(e.out, uint16(length-3))
+		}
+	}
+	e.writeBits(uint64(offset-1<<offsetBits), uint(offsetBits))
+}
+
+func (e *testEncoder) finish() []byte {
+	binary.LittleEndian.PutUint16(e.out[e.nextBits:], uint16(e.bitbuf<<(16-e.bitcount)))
+	binary.LittleEndian.PutUint16(e.out[e.nextBits2:], 0)
+	return e.out
+}
+
+func decompress(t *testing.T, in []byte, size int) []byte {
+	t.Helper()
+	out := make([]byte, size)
+	if err := Decompress(in, out); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestDecompressLiterals(t *testing.T) {
+	const text = "XPRESS Huffman literals, one 9-bit codeword each."
+	e := newTestEncoder()
+	e.literals(text)
+	if got := decompress(t, e.finish(), len(text)); string(got) != text {
+		t.Errorf("got %q, want %q", got, text)
+	}
+}
+
+// TestDecompressSpecExample decodes the LZ77+Huffman example of [MS-XCA],
+// which compresses the alphabet with 5-bit codewords for a to v, 4-bit ones
+// for w to z and a 4-bit end of data symbol.
+func TestDecompressSpecExample(t *testing.T) {
+	table := strings.Repeat("00", 0x30) +
+		"50" + strings.Repeat("55", 10) + "454404" +
+		strings.Repeat("00", 0x80-0x3e) + "04" + strings.Repeat("00", tableSize-0x81)
+	in, err := hex.DecodeString(table + "d8523ed794115be9195ff9d67cdf8d0400000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = "abcdefghijklmnopqrstuvwxyz"
+	if got := decompress(t, in, len(want)); string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestDecompressMatches(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		offset int
+		length int
+	}{
+		{"short", 3, 5},
+		{"byte length", 2, 40},
+		{"byte length limit", 1, 3 + 15 + 254},
+		{"word length", 2, 1000},
+		{"word length filling the chunk", 4, maxBlockSize - 5},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestEncoder()
+			e.literals("abcd")
+			e.match(tt.offset, tt.length)
+			e.literals("!")
+
+			want := []byte("abcd")
+			for range tt.length {
+				want = append(want, want[len(want)-tt.offset])
+			}
+			want = append(want, '!')
+
+			got := decompress(t, e.finish(), len(want))
+			if !bytes.Equal(got, want) {
+				t.Errorf("got %q..., want %q...", got[:min(len(got), 16)], want[:min(len(want), 16)])
+			}
+		})
+	}
+}
+
+func TestDecompressorReuse(t *testing.T) {
+	d := NewDecompressor()
+	for _, text := range []string{"first chunk", "second chunk"} {
+		e := newTestEncoder()
+		e.literals(text)
+		out := make([]byte, len(text))
+		if err := d.Decompress(e.finish(), out); err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != text {
+			t.Errorf("got %q, want %q", out, text)
+		}
+	}
+}
+
+func TestDecompressCorrupt(t *testing.T) {
+	check := func(name string, in []byte, size int) {
+		t.Helper()
+		if err := Decompress(in, make([]byte, size)); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+
+	e := newTestEncoder()
+	e.literals("ab")
+	e.match(5, 4)
+	check("offset past the output", e.finish(), 6)
+
+	e = newTestEncoder()
+	e.literals("ab")
+	e.match(2, 10)
+	check("length past the output", e.finish(), 8)
+
+	e = newTestEncoder()
+	e.literals("ab")
+	in := e.finish()
+	copy(in, bytes.Repeat([]byte{0x11}, tableSize))
+	check("oversubscribed length table", in, 2)
+	clear(in[:tableSize])
+	check("empty length table", in, 2)
+
+	e = newTestEncoder()
+	e.literals("ab")
+	e.match(2, 1000)
+	in = e.finish()
+	if err := Decompress(in, make([]byte, 1002)); err != nil {
+		t.Fatal(err)
+	}
+	for n := range len(in) {
+		check(fmt.Sprintf("truncated to %d bytes", n), in[:n], 1002)
+	}
+
+	if err := Decompress(in, make([]byte, maxBlockSize+1)); err == nil {
+		t.Error("no error for an oversized chunk")
+	}
+}
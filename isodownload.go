@@ -0,0 +1,654 @@
+package winfonts
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxDownloadAttempts is how many times each segment of a download is tried,
+// resuming where the previous attempt stopped, before giving up.
+const maxDownloadAttempts = 5
+
+// maxSegmentSize bounds the byte ranges a download is split into. Segments
+// are handed out to the connections as they become free, so a slow one only
+// holds up the segment it is working on.
+const maxSegmentSize = 64 << 20
+
+// errURLExpired is returned when the server no longer accepts the signed
+// download URL, which Microsoft only keeps valid for a day.
+var errURLExpired = errors.New("download URL has expired")
+
+// interruptedError is a download failure that resuming may get past, such as
+// a dropped connection.
+type interruptedError struct {
+	err error
+}
+
+func (e *interruptedError) Error() string { return e.err.Error() }
+func (e *interruptedError) Unwrap() error { return e.err }
+
+// downloadState is saved next to a partial download, so that a later attempt
+// can check that the server still has the same file and fetch only the
+// segments that are missing.
+type downloadState struct {
+	Size         int64             `json:"size"`
+	ETag         string            `json:"etag,omitempty"`
+	LastModified string            `json:"last_modified,omitempty"`
+	Segments     []downloadSegment `json:"segments"`
+}
+
+// downloadSegment is the byte range [Start, End) of a download, of which the
+// first Done bytes have been written.
+type downloadSegment struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+	Done  int64 `json:"done"`
+}
+
+func downloadStatePath(path string) string {
+	return path + ".download.json"
+}
+
+// loadDownloadState returns the state of the partial download at path, or nil
+// if there is nothing to resume.
+func loadDownloadState(path string) *downloadState {
+	data, err := os.ReadFile(downloadStatePath(path))
+	if err != nil {
+		return nil
+	}
+	var state downloadState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Size() != state.Size {
+		return nil
+	}
+	return &state
+}
+
+func (s *downloadState) save(path string) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(downloadStatePath(path), data, 0644)
+}
+
+// sameFile reports whether s describes the same remote file as other.
+func (s *downloadState) sameFile(other *downloadState) bool {
+	if s.Size != other.Size {
+		return false
+	}
+	if s.ETag != "" || other.ETag != "" {
+		return s.ETag == other.ETag
+	}
+	return s.LastModified == other.LastModified
+}
+
+// validator returns the If-Range value that makes the server send a range only
+// if the file is unchanged.
+func (s *downloadState) validator() string {
+	return ifRangeValidator(s.ETag, s.LastModified)
+}
+
+// ifRangeValidator returns the If-Range value for a file with the given ETag
+// and Last-Modified headers. Weak ETags never match in If-Range, so the
+// modification time is used instead; an empty result means neither can be.
+func ifRangeValidator(etag, lastModified string) string {
+	if etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return lastModified
+}
+
+// split divides the file into segments for the given number of connections.
+func (s *downloadState) split(connections int) {
+	size := min(maxSegmentSize, (s.Size+int64(connections)-1)/int64(connections))
+	size = max(size, 1)
+	s.Segments = nil
+	for start := int64(0); start < s.Size; start += size {
+		s.Segments = append(s.Segments, downloadSegment{Start: start, End: min(start+size, s.Size)})
+	}
+}
+
+// downloadURL is the URL being downloaded from, shared by the connections so
+// that it is only refreshed once when it expires.
+type downloadURL struct {
+	m       sync.Mutex
+	url     string
+	gen     int
+	refresh func() (string, error)
+}
+
+func (u *downloadURL) get() (string, int) {
+	u.m.Lock()
+	defer u.m.Unlock()
+	return u.url, u.gen
+}
+
+// renew replaces the URL obtained at generation gen, unless another
+// connection already did.
+func (u *downloadURL) renew(gen int) error {
+	u.m.Lock()
+	defer u.m.Unlock()
+	if u.gen != gen {
+		return nil
+	}
+	if u.refresh == nil {
+		return errURLExpired
+	}
+	log.Printf("Download URL has expired, requesting a new one...")
+	url, err := u.refresh()
+	if err != nil {
+		return fmt.Errorf("failed to refresh download URL: %w", err)
+	}
+	u.url = url
+	u.gen++
+	return nil
+}
+
+// Download downloads the ISO at url, as returned by GetDownloadURL, to path
+// over several connections, each fetching byte-range segments of the file into
+// a preallocated output. A partial file left by an earlier call is resumed when
+// its sidecar state file shows the server still has the same file. Each
+// segment is retried on its own when its transfer is interrupted, and when the
+// URL has expired, a new one is obtained from GetDownloadURL. Servers without
+// Range support are downloaded in a single stream.
+//
+// When Microsoft publishes a SHA-256 hash for the ISO, the file is hashed as
+// it is downloaded and a *ChecksumError is returned if it does not match.
+//
+// If ctx is cancelled, Download saves its progress and returns ctx.Err(); a
+// later call resumes where it stopped.
+func (w *WindowsDownloader) Download(ctx context.Context, url, path string) error {
+	u := &downloadURL{url: url}
+	if productEditionID := w.productEditionID; productEditionID != "" {
+		u.refresh = func() (string, error) {
+			return w.GetDownloadURL(ctx, productEditionID)
+		}
+	}
+
+	expectedSHA256, ok := w.ExpectedSHA256()
+	if !ok {
+		log.Printf("No SHA-256 hash is published for this ISO; it will not be verified")
+	}
+	var h hash.Hash
+	if expectedSHA256 != "" {
+		h = sha256.New()
+	}
+
+	remote, err := w.probeDownload(ctx, u)
+	if err != nil {
+		return err
+	}
+	if remote == nil {
+		if err := w.downloadStream(ctx, u, path, h); err != nil {
+			return err
+		}
+		return verifyDownload(path, h, expectedSHA256)
+	}
+
+	state := loadDownloadState(path)
+	if state != nil && state.sameFile(remote) {
+		log.Printf("Resuming partial download")
+	} else {
+		state = remote
+		state.split(w.connections)
+		f, err := os.Create(path)
+		if err == nil {
+			err = f.Truncate(state.Size)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		if err := state.save(path); err != nil {
+			return fmt.Errorf("failed to save download state: %w", err)
+		}
+	}
+
+	d := &segmentedDownload{
+		client:   w.client,
+		report:   w.progress,
+		url:      u,
+		path:     path,
+		state:    state,
+		progress: make(chan struct{}, 1),
+	}
+	if err := d.run(ctx, w.connections, h); err != nil {
+		return err
+	}
+	os.Remove(downloadStatePath(path))
+	return verifyDownload(path, h, expectedSHA256)
+}
+
+// verifyDownload compares the hash of a completed download with the one
+// Microsoft publishes. h is nil when there is nothing to compare with.
+func verifyDownload(path string, h hash.Hash, expected string) error {
+	if h == nil {
+		return nil
+	}
+	actual := hex.EncodeToString(h.Sum(nil))
+	if !strings.EqualFold(actual, expected) {
+		return &ChecksumError{File: path, Expected: expected, Actual: actual}
+	}
+	log.Printf("SHA-256 checksum verified")
+	return nil
+}
+
+// probeDownload requests the first byte of the file to learn its size and
+// validators. It returns nil if the server does not support Range requests.
+// Failed requests are retried with the backoff of the retry policy.
+func (w *WindowsDownloader) probeDownload(ctx context.Context, u *downloadURL) (*downloadState, error) {
+	for attempt := 1; ; attempt++ {
+		url, gen := u.get()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		req.Header.Set("Range", "bytes=0-0")
+		resp, err := w.client.Do(req)
+		if err != nil {
+			if attempt < maxDownloadAttempts && ctx.Err() == nil {
+				wait := w.retry.backoff(attempt)
+				log.Printf("Failed to reach download server: %v; retrying in %s", err, wait.Round(time.Millisecond))
+				select {
+				case <-time.After(wait):
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				}
+				continue
+			}
+			return nil, fmt.Errorf("failed to download file: %w", err)
+		}
+		resp.Body.Close()
+
+		switch resp.StatusCode {
+		case http.StatusPartialContent:
+			cr := resp.Header.Get("Content-Range")
+			_, total, _ := strings.Cut(cr, "/")
+			size, err := strconv.ParseInt(total, 10, 64)
+			if err != nil {
+				return nil, nil
+			}
+			return &downloadState{
+				Size:         size,
+				ETag:         resp.Header.Get("ETag"),
+				LastModified: resp.Header.Get("Last-Modified"),
+			}, nil
+		case http.StatusOK:
+			return nil, nil
+		case http.StatusUnauthorized, http.StatusForbidden, http.StatusGone:
+			if attempt < maxDownloadAttempts {
+				if err := u.renew(gen); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			return nil, fmt.Errorf("%w (%s)", errURLExpired, resp.Status)
+		default:
+			return nil, fmt.Errorf("bad status: %s", resp.Status)
+		}
+	}
+}
+
+// segmentedDownload fetches the missing segments of a download.
+type segmentedDownload struct {
+	client *http.Client
+	report func(done, total int64)
+	url    *downloadURL
+	path   string
+	done   int64
+
+	// m guards the progress recorded in state. progress is signalled
+	// whenever data is written.
+	m        sync.Mutex
+	state    *downloadState
+	progress chan struct{}
+}
+
+// contiguous returns how many bytes at the start of the file are complete.
+func (d *segmentedDownload) contiguous() int64 {
+	d.m.Lock()
+	defer d.m.Unlock()
+	var n int64
+	for _, seg := range d.state.Segments {
+		n = seg.Start + seg.Done
+		if seg.Done < seg.End-seg.Start {
+			break
+		}
+	}
+	return n
+}
+
+// hash feeds h the file as its start completes, so that the download is
+// verified without reading it back afterwards. It returns once stop is
+// closed and no more data is complete.
+func (d *segmentedDownload) hash(f io.ReaderAt, h hash.Hash, stop <-chan struct{}) error {
+	var off int64
+	for {
+		if end := d.contiguous(); end > off {
+			n, err := io.Copy(h, io.NewSectionReader(f, off, end-off))
+			off += n
+			if err != nil {
+				return fmt.Errorf("failed to hash file: %w", err)
+			}
+			continue
+		}
+		if off == d.state.Size {
+			return nil
+		}
+		select {
+		case <-d.progress:
+		case <-stop:
+			if d.contiguous() == off {
+				return nil
+			}
+		}
+	}
+}
+
+func (d *segmentedDownload) run(ctx context.Context, connections int, h hash.Hash) error {
+	f, err := os.OpenFile(d.path, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open output file: %w", err)
+	}
+	defer f.Close()
+
+	var todo []int
+	for i, seg := range d.state.Segments {
+		d.done += seg.Done
+		if seg.Done < seg.End-seg.Start {
+			todo = append(todo, i)
+		}
+	}
+	if d.report != nil {
+		d.report(d.done, d.state.Size)
+	}
+
+	// Save the progress now and then, so that a run that is killed can
+	// be resumed without fetching everything again.
+	stopSaving := make(chan struct{})
+	saved := make(chan struct{})
+	go func() {
+		defer close(saved)
+		ticker := time.NewTicker(5 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				d.save()
+			case <-stopSaving:
+				return
+			}
+		}
+	}()
+
+	stopHashing := make(chan struct{})
+	hashed := make(chan error, 1)
+	if h != nil {
+		go func() { hashed <- d.hash(f, h, stopHashing) }()
+	} else {
+		hashed <- nil
+	}
+
+	connections = max(connections, 1)
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	segments := make(chan int)
+	errs := make(chan error, connections)
+	var wg sync.WaitGroup
+	for range connections {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range segments {
+				if err := d.fetchSegment(ctx, f, i); err != nil {
+					errs <- err
+					cancel()
+					return
+				}
+			}
+		}()
+	}
+feed:
+	for _, i := range todo {
+		select {
+		case segments <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(segments)
+	wg.Wait()
+	close(stopSaving)
+	<-saved
+	close(stopHashing)
+	hashErr := <-hashed
+
+	if err := parent.Err(); err != nil {
+		d.save()
+		return err
+	}
+	select {
+	case err := <-errs:
+		d.save()
+		return err
+	default:
+	}
+	if hashErr != nil {
+		return hashErr
+	}
+	if err := f.Close(); err != nil {
+		d.save()
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	return nil
+}
+
+func (d *segmentedDownload) save() {
+	d.m.Lock()
+	defer d.m.Unlock()
+	d.state.save(d.path)
+}
+
+// fetchSegment downloads what is missing of segment i, retrying it when the
+// transfer is interrupted.
+func (d *segmentedDownload) fetchSegment(ctx context.Context, f *os.File, i int) error {
+	var err error
+	for attempt := 1; attempt <= maxDownloadAttempts; attempt++ {
+		url, gen := d.url.get()
+		err = d.fetchRange(ctx, f, url, i)
+		var interrupted *interruptedError
+		switch {
+		case err == nil || ctx.Err() != nil:
+			return err
+		case errors.Is(err, errURLExpired):
+			if rerr := d.url.renew(gen); rerr != nil {
+				return rerr
+			}
+		case !errors.As(err, &interrupted):
+			return err
+		default:
+			// Give a struggling server a moment before retrying.
+			select {
+			case <-time.After(time.Duration(attempt) * time.Second):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	return err
+}
+
+func (d *segmentedDownload) fetchRange(ctx context.Context, f *os.File, url string, i int) error {
+	d.m.Lock()
+	seg := d.state.Segments[i]
+	d.m.Unlock()
+	start := seg.Start + seg.Done
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, seg.End-1))
+	if v := d.state.validator(); v != "" {
+		req.Header.Set("If-Range", v)
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return &interruptedError{fmt.Errorf("failed to download file: %w", err)}
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		if !strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", start)) {
+			return fmt.Errorf("server returned the wrong range: %s", resp.Header.Get("Content-Range"))
+		}
+	case http.StatusOK:
+		// Only sent when If-Range no longer matches. The next run
+		// sees the new validators and starts over.
+		return errors.New("file changed on the server during the download")
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusGone:
+		return fmt.Errorf("%w (%s)", errURLExpired, resp.Status)
+	default:
+		return &interruptedError{fmt.Errorf("bad status: %s", resp.Status)}
+	}
+
+	buf := make([]byte, 256<<10)
+	off := start
+	for off < seg.End {
+		n, err := resp.Body.Read(buf[:min(int64(len(buf)), seg.End-off)])
+		if n > 0 {
+			if _, werr := f.WriteAt(buf[:n], off); werr != nil {
+				return fmt.Errorf("failed to write file: %w", werr)
+			}
+			off += int64(n)
+			d.m.Lock()
+			d.state.Segments[i].Done = off - seg.Start
+			d.done += int64(n)
+			if d.report != nil {
+				d.report(d.done, d.state.Size)
+			}
+			d.m.Unlock()
+			select {
+			case d.progress <- struct{}{}:
+			default:
+			}
+		}
+		if err == io.EOF && off < seg.End {
+			return &interruptedError{fmt.Errorf("segment ended at %d of %d bytes", off-seg.Start, seg.End-seg.Start)}
+		}
+		if err != nil && err != io.EOF {
+			return &interruptedError{fmt.Errorf("failed to download file: %w", err)}
+		}
+	}
+	return nil
+}
+
+// downloadStream downloads the whole file in one request, for servers that
+// do not support Range requests. Such downloads cannot be resumed. The file is
+// also written to h unless it is nil.
+func (w *WindowsDownloader) downloadStream(ctx context.Context, u *downloadURL, path string, h hash.Hash) error {
+	var err error
+	for attempt := 1; attempt <= maxDownloadAttempts; attempt++ {
+		url, gen := u.get()
+		if h != nil {
+			h.Reset()
+		}
+		err = w.downloadStreamAttempt(ctx, url, path, h)
+		var interrupted *interruptedError
+		switch {
+		case err == nil || ctx.Err() != nil:
+			return err
+		case errors.Is(err, errURLExpired):
+			if rerr := u.renew(gen); rerr != nil {
+				return rerr
+			}
+		case errors.As(err, &interrupted):
+			log.Printf("Download interrupted: %v", err)
+		default:
+			return err
+		}
+	}
+	return err
+}
+
+func (w *WindowsDownloader) downloadStreamAttempt(ctx context.Context, url, path string, h hash.Hash) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return &interruptedError{fmt.Errorf("failed to download file: %w", err)}
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusGone:
+		return fmt.Errorf("%w (%s)", errURLExpired, resp.Status)
+	default:
+		return fmt.Errorf("bad status: %s", resp.Status)
+	}
+
+	out, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer out.Close()
+
+	var dst io.Writer = out
+	if h != nil {
+		dst = io.MultiWriter(out, h)
+	}
+	if w.progress != nil {
+		dst = &progressWriter{dst, 0, resp.ContentLength, w.progress}
+	}
+	_, err = io.Copy(dst, resp.Body)
+	if err != nil {
+		var pathErr *os.PathError
+		if errors.As(err, &pathErr) {
+			return fmt.Errorf("failed to write file: %w", err)
+		}
+		return &interruptedError{fmt.Errorf("failed to download file: %w", err)}
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	return nil
+}
+
+// progressWriter reports how much has been written through it.
+type progressWriter struct {
+	w      io.Writer
+	done   int64
+	total  int64
+	report func(done, total int64)
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.done += int64(n)
+	p.report(p.done, p.total)
+	return n, err
+}
@@ -0,0 +1,248 @@
+package winfonts
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// isoServer serves an ISO, with Range support unless noRanges is set.
+// Requests for a range of the file other than the first byte can be handled
+// by serve instead, to interrupt them.
+type isoServer struct {
+	*httptest.Server
+
+	m        sync.Mutex
+	data     []byte
+	etag     string
+	noRanges bool
+	ranges   []string // Range headers received, in order
+	serve    func(w http.ResponseWriter, r *http.Request, n int) bool
+}
+
+func newISOServer(t *testing.T, data []byte) *isoServer {
+	s := &isoServer{data: data, etag: `"v1"`}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.m.Lock()
+		rng := r.Header.Get("Range")
+		s.ranges = append(s.ranges, rng)
+		n := len(s.ranges)
+		data, etag, noRanges, serve := s.data, s.etag, s.noRanges, s.serve
+		s.m.Unlock()
+
+		if noRanges {
+			w.Write(data)
+			return
+		}
+		if serve != nil && rng != "bytes=0-0" && serve(w, r, n) {
+			return
+		}
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *isoServer) setFile(data []byte, etag string) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.data, s.etag = data, etag
+}
+
+// requests returns the Range headers received since the last call.
+func (s *isoServer) requests() []string {
+	s.m.Lock()
+	defer s.m.Unlock()
+	ranges := s.ranges
+	s.ranges = nil
+	return ranges
+}
+
+func randomISO(size int) []byte {
+	data := make([]byte, size)
+	r := rand.NewChaCha8([32]byte{})
+	r.Read(data)
+	return data
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// isoDownloader returns a downloader that expects the ISO it downloads to
+// have the SHA-256 hash sum, if it is not empty.
+func isoDownloader(sum string, opts ...DownloaderOption) *WindowsDownloader {
+	opts = append([]DownloaderOption{WithRetryPolicy(fastRetries)}, opts...)
+	w := NewWindowsDownloader(Windows11, EditionPro, ArchX64, LanguageEnglishUS, opts...)
+	if sum != "" {
+		w.sku = &SKUInfo{Language: "English"}
+		w.hashes = map[string]string{hashLabel("English", ArchX64): sum}
+	}
+	return w
+}
+
+func checkDownload(t *testing.T, path string, want []byte) {
+	t.Helper()
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("downloaded %d bytes that differ from the %d served", len(got), len(want))
+	}
+	if _, err := os.Stat(downloadStatePath(path)); !os.IsNotExist(err) {
+		t.Errorf("download state left behind: %v", err)
+	}
+}
+
+// interruptDownload starts downloading the file of s to path over a single
+// connection, and cancels the download once half of it has arrived, leaving
+// a partial file to resume.
+func interruptDownload(t *testing.T, s *isoServer, path string) {
+	t.Helper()
+	half := int64(len(s.data) / 2)
+	s.serve = func(w http.ResponseWriter, r *http.Request, n int) bool {
+		w.Header().Set("ETag", s.etag)
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-%d/%d", len(s.data)-1, len(s.data)))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(s.data[:half])
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+		return true
+	}
+	defer func() { s.serve = nil }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w := isoDownloader("", WithConnections(1), WithProgress(func(done, total int64) {
+		if done >= half {
+			cancel()
+		}
+	}))
+	if err := w.Download(ctx, s.URL, path); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want context.Canceled", err)
+	}
+	state := loadDownloadState(path)
+	if state == nil || state.Segments[0].Done != half {
+		t.Fatalf("saved state %+v, want %d bytes done", state, half)
+	}
+	s.requests()
+}
+
+func TestDownloadResumes(t *testing.T) {
+	data := randomISO(100 << 10)
+	s := newISOServer(t, data)
+	path := filepath.Join(t.TempDir(), "windows.iso")
+	interruptDownload(t, s, path)
+
+	w := isoDownloader(sha256Hex(data), WithConnections(1))
+	if err := w.Download(context.Background(), s.URL, path); err != nil {
+		t.Fatal(err)
+	}
+	checkDownload(t, path, data)
+	want := []string{"bytes=0-0", fmt.Sprintf("bytes=%d-%d", len(data)/2, len(data)-1)}
+	if got := s.requests(); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("got requests for ranges %q, want %q", got, want)
+	}
+}
+
+func TestDownloadRestartsChangedFile(t *testing.T) {
+	s := newISOServer(t, randomISO(100<<10))
+	path := filepath.Join(t.TempDir(), "windows.iso")
+	interruptDownload(t, s, path)
+
+	data := bytes.Repeat([]byte("changed"), 10<<10)
+	s.setFile(data, `"v2"`)
+	w := isoDownloader(sha256Hex(data), WithConnections(2))
+	if err := w.Download(context.Background(), s.URL, path); err != nil {
+		t.Fatal(err)
+	}
+	checkDownload(t, path, data)
+	got := s.requests()
+	restarted := slices.ContainsFunc(got, func(r string) bool {
+		return r != "bytes=0-0" && strings.HasPrefix(r, "bytes=0-")
+	})
+	if len(got) != 3 || !restarted {
+		t.Errorf("got requests for ranges %q, want the whole file again", got)
+	}
+}
+
+func TestDownloadWithoutRanges(t *testing.T) {
+	data := randomISO(100 << 10)
+	s := newISOServer(t, data)
+	s.noRanges = true
+	path := filepath.Join(t.TempDir(), "windows.iso")
+
+	w := isoDownloader(sha256Hex(data), WithConnections(4))
+	if err := w.Download(context.Background(), s.URL, path); err != nil {
+		t.Fatal(err)
+	}
+	checkDownload(t, path, data)
+	if got := s.requests(); len(got) != 2 || got[1] != "" {
+		t.Errorf("got requests for ranges %q, want a probe then the whole file", got)
+	}
+}
+
+func TestDownloadChecksumMismatch(t *testing.T) {
+	for _, noRanges := range []bool{false, true} {
+		t.Run(fmt.Sprintf("noRanges=%v", noRanges), func(t *testing.T) {
+			data := randomISO(10 << 10)
+			s := newISOServer(t, data)
+			s.noRanges = noRanges
+			path := filepath.Join(t.TempDir(), "windows.iso")
+
+			want := sha256Hex([]byte("another ISO"))
+			err := isoDownloader(want).Download(context.Background(), s.URL, path)
+			var sumErr *ChecksumError
+			if !errors.As(err, &sumErr) {
+				t.Fatalf("got error %v, want a ChecksumError", err)
+			}
+			if sumErr.Expected != want || sumErr.Actual != sha256Hex(data) {
+				t.Errorf("got %+v, want expected %s and actual %s", sumErr, want, sha256Hex(data))
+			}
+		})
+	}
+}
+
+func TestDownloadProbeBacksOff(t *testing.T) {
+	data := randomISO(10 << 10)
+	s := newISOServer(t, data)
+	var failed int
+	handler := s.Config.Handler
+	s.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failed < 2 {
+			failed++
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+	path := filepath.Join(t.TempDir(), "windows.iso")
+
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: 50 * time.Millisecond}
+	w := isoDownloader("", WithRetryPolicy(policy))
+	start := time.Now()
+	if err := w.Download(context.Background(), s.URL, path); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("probe retried after %s, want at least 150ms of backoff", elapsed)
+	}
+	checkDownload(t, path, data)
+}
@@ -0,0 +1,375 @@
+package winfonts
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"path"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/Xmister/udf"
+	"github.com/kdomanski/iso9660"
+)
+
+// isoFile is a file or directory of an ISO filesystem.
+type isoFile struct {
+	path  string // slash-separated path from the root of the filesystem
+	name  string
+	isDir bool
+	size  int64
+	data  io.ReaderAt
+}
+
+// isoFS is a filesystem found on an ISO image.
+type isoFS interface {
+	// files walks the filesystem, yielding every file and directory.
+	// Iteration stops at the first error.
+	files() iter.Seq2[isoFile, error]
+}
+
+// openISO detects the filesystem of an ISO image. UDF is preferred, as it is
+// what Windows media are authored with; images without a usable UDF volume are
+// read as ISO9660 instead.
+func openISO(ra io.ReaderAt) (isoFS, error) {
+	u, udfErr := openUDF(ra)
+	if udfErr == nil {
+		return udfFS{u}, nil
+	}
+	fs, err := openISO9660(ra)
+	if err != nil {
+		return nil, fmt.Errorf("image is neither UDF (%v) nor ISO9660 (%w)", udfErr, err)
+	}
+	return fs, nil
+}
+
+// openUDF opens the UDF volume of ra. The udf package panics when a read
+// fails, which happens on images too short to hold the UDF anchor.
+func openUDF(ra io.ReaderAt) (u *udf.Udf, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			u, err = nil, fmt.Errorf("failed to read UDF volume: %v", r)
+		}
+	}()
+	return udf.NewUdfFromReader(ra)
+}
+
+type udfFS struct {
+	iso *udf.Udf
+}
+
+func (fs udfFS) files() iter.Seq2[isoFile, error] {
+	return func(yield func(isoFile, error) bool) {
+		var walk func(string, []udf.File) bool
+
+		walk = func(dir string, files []udf.File) bool {
+			for _, item := range files {
+				f := isoFile{
+					path:  path.Join(dir, item.Name()),
+					name:  item.Name(),
+					isDir: item.IsDir(),
+				}
+				if !f.isDir {
+					f.size = item.Size()
+					f.data = item.NewReader()
+				}
+				if !yield(f, nil) {
+					return false
+				}
+
+				if f.isDir {
+					children, err := fs.readDir(&item)
+					if err != nil {
+						yield(isoFile{}, err)
+						return false
+					}
+					if !walk(f.path, children) {
+						return false
+					}
+				}
+			}
+			return true
+		}
+
+		root, err := fs.readDir(nil)
+		if err != nil {
+			yield(isoFile{}, err)
+			return
+		}
+		walk("", root)
+	}
+}
+
+// readDir lists a directory, or the root directory if dir is nil, turning the
+// panics of the udf package into errors.
+func (fs udfFS) readDir(dir *udf.File) (files []udf.File, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			files, err = nil, fmt.Errorf("failed to read UDF directory: %v", r)
+		}
+	}()
+	if dir == nil {
+		return fs.iso.ReadDir(nil), nil
+	}
+	return fs.iso.ReadDir(dir.FileEntry()), nil
+}
+
+// singleFileFS presents a single file, such as a bare WIM, as a filesystem
+// holding only that file, so it goes through the same extraction as the WIM
+// files of an ISO.
+type singleFileFS struct {
+	file isoFile
+}
+
+func (fs singleFileFS) files() iter.Seq2[isoFile, error] {
+	return func(yield func(isoFile, error) bool) {
+		yield(fs.file, nil)
+	}
+}
+
+const (
+	isoSectorSize = 2048
+
+	// ISO9660 directory record flags
+	isoFlagDirectory   = 0x02
+	isoFlagMultiExtent = 0x80
+)
+
+// openISO9660 opens an ISO9660 filesystem. Joliet names are preferred, as
+// they are the long names Windows itself shows; without a Joliet volume the
+// primary volume is read, with Rock Ridge names when it has them.
+func openISO9660(ra io.ReaderAt) (isoFS, error) {
+	root, ok, err := findJolietRoot(ra)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return jolietFS{ra: ra, root: root}, nil
+	}
+	img, err := iso9660.OpenImage(ra)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ISO9660 volume: %w", err)
+	}
+	return iso9660FS{img}, nil
+}
+
+// findJolietRoot looks for a Joliet supplementary volume descriptor and
+// returns the root directory record it holds.
+func findJolietRoot(ra io.ReaderAt) (iso9660.DirectoryEntry, bool, error) {
+	var root iso9660.DirectoryEntry
+	buf := make([]byte, isoSectorSize)
+	// Volume descriptors start after the 16 sectors of the system area.
+	for sector := int64(16); ; sector++ {
+		if _, err := ra.ReadAt(buf, sector*isoSectorSize); err != nil {
+			return root, false, fmt.Errorf("failed to read volume descriptor: %w", err)
+		}
+		if string(buf[1:6]) != "CD001" {
+			return root, false, errors.New("not an ISO9660 image")
+		}
+		switch buf[0] {
+		case 255:
+			// Volume descriptor set terminator
+			return root, false, nil
+		case 2:
+			// Supplementary volume descriptor. The escape sequences
+			// select UCS-2 level 1, 2 or 3 for Joliet.
+			esc := string(buf[88:91])
+			if esc != "%/@" && esc != "%/C" && esc != "%/E" {
+				continue
+			}
+			if err := root.UnmarshalBinary(buf[156:190]); err != nil {
+				return root, false, fmt.Errorf("invalid Joliet root directory: %w", err)
+			}
+			return root, true, nil
+		}
+	}
+}
+
+// jolietName decodes a Joliet file identifier, which is UCS-2 big-endian and
+// may end with a ";1" version suffix.
+func jolietName(id string) string {
+	u := make([]uint16, len(id)/2)
+	for i := range u {
+		u[i] = binary.BigEndian.Uint16([]byte(id[2*i:]))
+	}
+	name, _, _ := strings.Cut(string(utf16.Decode(u)), ";")
+	return name
+}
+
+type jolietFS struct {
+	ra   io.ReaderAt
+	root iso9660.DirectoryEntry
+}
+
+func (fs jolietFS) files() iter.Seq2[isoFile, error] {
+	return func(yield func(isoFile, error) bool) {
+		var walk func(string, *iso9660.DirectoryEntry) bool
+
+		walk = func(dir string, de *iso9660.DirectoryEntry) bool {
+			entries, err := fs.readDir(de)
+			if err != nil {
+				yield(isoFile{}, fmt.Errorf("failed to read directory %s: %w", dir, err))
+				return false
+			}
+			// Files larger than 4 GiB, such as many install.wim, are
+			// recorded as several consecutive entries of the same name,
+			// one per extent.
+			var extents []*iso9660.DirectoryEntry
+			for _, entry := range entries {
+				extents = append(extents, entry)
+				if entry.FileFlags&isoFlagMultiExtent != 0 {
+					continue
+				}
+
+				name := jolietName(entry.Identifier)
+				f := isoFile{
+					path:  path.Join(dir, name),
+					name:  name,
+					isDir: entry.FileFlags&isoFlagDirectory != 0,
+				}
+				if !f.isDir {
+					f.data, f.size = extentReader(fs.ra, extents)
+				}
+				extents = nil
+				if !yield(f, nil) {
+					return false
+				}
+				if f.isDir && !walk(f.path, entry) {
+					return false
+				}
+			}
+			return true
+		}
+
+		walk("", &fs.root)
+	}
+}
+
+// readDir returns the directory records of de, leaving out its "." and ".."
+// entries.
+func (fs jolietFS) readDir(de *iso9660.DirectoryEntry) ([]*iso9660.DirectoryEntry, error) {
+	data := make([]byte, de.ExtentLength)
+	if _, err := fs.ra.ReadAt(data, int64(de.ExtentLocation)*isoSectorSize); err != nil {
+		return nil, err
+	}
+	var entries []*iso9660.DirectoryEntry
+	for sector := 0; sector < len(data); sector += isoSectorSize {
+		// Records never cross sector boundaries; the rest of a sector
+		// after the last record is zero.
+		buf := data[sector:min(sector+isoSectorSize, len(data))]
+		for i := 0; i < len(buf) && buf[i] != 0; {
+			length := int(buf[i])
+			if length < 34 || i+length > len(buf) || 33+int(buf[i+32]) > length {
+				return nil, errors.New("invalid directory record")
+			}
+			entry := &iso9660.DirectoryEntry{}
+			if err := entry.UnmarshalBinary(buf[i : i+length]); err != nil {
+				return nil, err
+			}
+			i += length
+			if entry.Identifier == "\x00" || entry.Identifier == "\x01" {
+				continue
+			}
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+type iso9660FS struct {
+	img *iso9660.Image
+}
+
+func (fs iso9660FS) files() iter.Seq2[isoFile, error] {
+	return func(yield func(isoFile, error) bool) {
+		var walk func(string, *iso9660.File) bool
+
+		walk = func(dir string, d *iso9660.File) bool {
+			children, err := d.GetChildren()
+			if err != nil {
+				yield(isoFile{}, fmt.Errorf("failed to read directory %s: %w", dir, err))
+				return false
+			}
+			for i := 0; i < len(children); i++ {
+				child := children[i]
+				f := isoFile{
+					path:  path.Join(dir, child.Name()),
+					name:  child.Name(),
+					isDir: child.IsDir(),
+				}
+				if !f.isDir {
+					// The iso9660 package does not expose the
+					// multi-extent flag, but the extents of a file
+					// are its only entries that share a name.
+					readers := []*io.SectionReader{child.Reader().(*io.SectionReader)}
+					f.size = child.Size()
+					for i+1 < len(children) && !children[i+1].IsDir() && children[i+1].Name() == child.Name() {
+						i++
+						readers = append(readers, children[i].Reader().(*io.SectionReader))
+						f.size += children[i].Size()
+					}
+					f.data = concatReaderAt(readers)
+				}
+				if !yield(f, nil) {
+					return false
+				}
+				if f.isDir && !walk(f.path, child) {
+					return false
+				}
+			}
+			return true
+		}
+
+		root, err := fs.img.RootDir()
+		if err != nil {
+			yield(isoFile{}, fmt.Errorf("failed to read root directory: %w", err))
+			return
+		}
+		walk("", root)
+	}
+}
+
+// extentReader returns a reader over the data of a file recorded in one or
+// more extents, along with its size.
+func extentReader(ra io.ReaderAt, extents []*iso9660.DirectoryEntry) (io.ReaderAt, int64) {
+	readers := make([]*io.SectionReader, len(extents))
+	var size int64
+	for i, e := range extents {
+		readers[i] = io.NewSectionReader(ra, int64(e.ExtentLocation)*isoSectorSize, int64(e.ExtentLength))
+		size += int64(e.ExtentLength)
+	}
+	return concatReaderAt(readers), size
+}
+
+// concatReaderAt returns a ReaderAt over the concatenation of parts.
+func concatReaderAt(parts []*io.SectionReader) io.ReaderAt {
+	if len(parts) == 1 {
+		return parts[0]
+	}
+	return multiReaderAt(parts)
+}
+
+type multiReaderAt []*io.SectionReader
+
+func (m multiReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for _, part := range m {
+		size := part.Size()
+		if off >= size {
+			off -= size
+			continue
+		}
+		k, err := part.ReadAt(p[n:min(len(p), n+int(size-off))], off)
+		n += k
+		if err != nil && err != io.EOF {
+			return n, err
+		}
+		if n == len(p) {
+			return n, nil
+		}
+		off = 0
+	}
+	return n, io.EOF
+}
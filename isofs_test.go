@@ -0,0 +1,184 @@
+package winfonts
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/kdomanski/iso9660"
+)
+
+// jolietID encodes name as a Joliet file identifier.
+func jolietID(name string) string {
+	var b bytes.Buffer
+	binary.Write(&b, binary.BigEndian, utf16.Encode([]rune(name)))
+	return b.String()
+}
+
+// isoRecord encodes a directory record.
+func isoRecord(t *testing.T, id string, sector, length uint32, flags byte) []byte {
+	t.Helper()
+	de := iso9660.DirectoryEntry{
+		ExtentLocation:       int32(sector),
+		ExtentLength:         length,
+		FileFlags:            flags,
+		VolumeSequenceNumber: 1,
+		Identifier:           id,
+	}
+	b, err := de.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// buildJolietISO returns an image with only a Joliet volume, whose root holds
+// readme.txt and a sources directory with install.wim recorded in two
+// extents.
+func buildJolietISO(t *testing.T, readme, wim []byte) []byte {
+	t.Helper()
+	const (
+		rootSector    = 19
+		sourcesSector = 20
+		readmeSector  = 21
+		wimSector     = 22
+	)
+	img := make([]byte, (wimSector+2)*isoSectorSize)
+	sector := func(n int) []byte { return img[n*isoSectorSize : (n+1)*isoSectorSize] }
+
+	pvd := sector(16)
+	pvd[0] = 1
+	copy(pvd[1:], "CD001")
+	svd := sector(17)
+	svd[0] = 2
+	copy(svd[1:], "CD001")
+	copy(svd[88:], "%/E")
+	copy(svd[156:], isoRecord(t, "\x00", rootSector, isoSectorSize, isoFlagDirectory))
+	term := sector(18)
+	term[0] = 255
+	copy(term[1:], "CD001")
+
+	root := [][]byte{
+		isoRecord(t, "\x00", rootSector, isoSectorSize, isoFlagDirectory),
+		isoRecord(t, "\x01", rootSector, isoSectorSize, isoFlagDirectory),
+		isoRecord(t, jolietID("readme.txt;1"), readmeSector, uint32(len(readme)), 0),
+		isoRecord(t, jolietID("sources"), sourcesSector, isoSectorSize, isoFlagDirectory),
+	}
+	copy(sector(rootSector), bytes.Join(root, nil))
+
+	sources := [][]byte{
+		isoRecord(t, "\x00", sourcesSector, isoSectorSize, isoFlagDirectory),
+		isoRecord(t, "\x01", rootSector, isoSectorSize, isoFlagDirectory),
+		isoRecord(t, jolietID("install.wim;1"), wimSector, isoSectorSize, isoFlagMultiExtent),
+		isoRecord(t, jolietID("install.wim;1"), wimSector+1, uint32(len(wim)-isoSectorSize), 0),
+	}
+	copy(sector(sourcesSector), bytes.Join(sources, nil))
+
+	copy(sector(readmeSector), readme)
+	copy(img[wimSector*isoSectorSize:], wim)
+	return img
+}
+
+// isoFiles returns the files of fs by path, failing the test on errors.
+func isoFiles(t *testing.T, fs isoFS) map[string]isoFile {
+	t.Helper()
+	files := make(map[string]isoFile)
+	for f, err := range fs.files() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.path] = f
+	}
+	return files
+}
+
+func checkISOFile(t *testing.T, files map[string]isoFile, name string, want []byte) {
+	t.Helper()
+	f, ok := files[name]
+	if !ok {
+		t.Fatalf("no file %s", name)
+	}
+	if f.isDir || f.size != int64(len(want)) {
+		t.Fatalf("%s: got isDir %v, size %d, want a file of %d bytes", name, f.isDir, f.size, len(want))
+	}
+	got, err := io.ReadAll(io.NewSectionReader(f.data, 0, f.size))
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s: data differs", name)
+	}
+}
+
+func TestOpenISOJolietMultiExtent(t *testing.T) {
+	readme := []byte("fonts are in install.wim")
+	wim := make([]byte, isoSectorSize+100)
+	for i := range wim {
+		wim[i] = byte(i * 7)
+	}
+	fs, err := openISO(bytes.NewReader(buildJolietISO(t, readme, wim)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fs.(jolietFS); !ok {
+		t.Fatalf("opened image as %T, want jolietFS", fs)
+	}
+
+	files := isoFiles(t, fs)
+	if len(files) != 3 {
+		t.Errorf("got %d entries, want 3", len(files))
+	}
+	if f := files["sources"]; !f.isDir {
+		t.Error("sources is not a directory")
+	}
+	checkISOFile(t, files, "readme.txt", readme)
+	checkISOFile(t, files, "sources/install.wim", wim)
+
+	// A read across the two extents
+	buf := make([]byte, 16)
+	n, err := files["sources/install.wim"].data.ReadAt(buf, isoSectorSize-8)
+	if err != nil || n != len(buf) {
+		t.Fatalf("ReadAt across extents = %d, %v", n, err)
+	}
+	if !bytes.Equal(buf, wim[isoSectorSize-8:isoSectorSize+8]) {
+		t.Error("ReadAt across extents returned the wrong data")
+	}
+	// A read past the end
+	n, err = files["sources/install.wim"].data.ReadAt(buf, int64(len(wim))-4)
+	if n != 4 || err != io.EOF {
+		t.Errorf("ReadAt past the end = %d, %v, want 4, EOF", n, err)
+	}
+}
+
+func TestOpenISOFallsBackToISO9660(t *testing.T) {
+	iw, err := iso9660.NewWriter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer iw.Cleanup()
+	data := []byte("not really a WIM")
+	if err := iw.AddFile(bytes.NewReader(data), "sources/install.wim"); err != nil {
+		t.Fatal(err)
+	}
+	var img bytes.Buffer
+	if err := iw.WriteTo(&img, "TEST"); err != nil {
+		t.Fatal(err)
+	}
+
+	fs, err := openISO(bytes.NewReader(img.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fs.(iso9660FS); !ok {
+		t.Fatalf("opened image as %T, want iso9660FS", fs)
+	}
+	checkISOFile(t, isoFiles(t, fs), "sources/install.wim", data)
+}
+
+func TestOpenISORejectsOtherData(t *testing.T) {
+	if _, err := openISO(bytes.NewReader(make([]byte, 20*isoSectorSize))); err == nil {
+		t.Error("no error for an image without a filesystem")
+	}
+}
@@ -0,0 +1,299 @@
+package winfonts
+
+import (
+	"cmp"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"iter"
+	"log"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/actions-precompiled/winfonts/internal/wim"
+)
+
+// NewISOSource returns a Source for the fonts in the WIM files of an ISO image.
+// The filesystem is detected: UDF, as Windows media use, or ISO9660 with
+// Joliet or Rock Ridge names.
+func NewISOSource(ra io.ReaderAt) (Source, error) {
+	fs, err := openISO(ra)
+	if err != nil {
+		return nil, err
+	}
+	return isoSource{fs}, nil
+}
+
+// NewUDFSource is like NewISOSource, but only reads the UDF filesystem.
+func NewUDFSource(ra io.ReaderAt) (Source, error) {
+	u, err := openUDF(ra)
+	if err != nil {
+		return nil, err
+	}
+	return isoSource{udfFS{u}}, nil
+}
+
+// NewISO9660Source is like NewISOSource, but only reads the ISO9660
+// filesystem, even on images that also have a UDF one.
+func NewISO9660Source(ra io.ReaderAt) (Source, error) {
+	fs, err := openISO9660(ra)
+	if err != nil {
+		return nil, err
+	}
+	return isoSource{fs}, nil
+}
+
+// NewWimSource returns a Source for a bare WIM or ESD file that is not wrapped
+// in an ISO. name is the file name of the WIM, which is recorded in the
+// origins of the fonts and must have a .wim or .esd extension.
+func NewWimSource(ra io.ReaderAt, name string) (Source, error) {
+	name = filepath.Base(name)
+	if !isWimName(name) {
+		return nil, fmt.Errorf("%s is not a .wim or .esd file", name)
+	}
+	return isoSource{singleFileFS{isoFile{path: name, name: name, data: ra}}}, nil
+}
+
+// isoSource finds fonts in the WIM files of an ISO filesystem.
+type isoSource struct {
+	fs isoFS
+}
+
+func (s isoSource) Candidates(ctx context.Context, search SearchOptions) iter.Seq2[FontCandidate, error] {
+	return func(yield func(FontCandidate, error) bool) {
+		splitSets := make(map[string][]isoFile)
+		var splitKeys []string
+		for item, err := range s.fs.files() {
+			if err == nil {
+				err = ctx.Err()
+			}
+			if err != nil {
+				yield(FontCandidate{}, err)
+				return
+			}
+			log.Printf("isofile: %s %s", item.name, filepath.Ext(item.name))
+			if item.isDir {
+				continue
+			}
+			if key, ok := splitWimSet(item.path); ok {
+				if splitSets[key] == nil {
+					splitKeys = append(splitKeys, key)
+				}
+				splitSets[key] = append(splitSets[key], item)
+				continue
+			}
+			if !isWimName(item.name) {
+				continue
+			}
+
+			wimName := item.name
+			log.Printf("Processing WIM file: %s", wimName)
+			bundle, err := wim.NewReader(item.data)
+			if err != nil {
+				yield(FontCandidate{}, fmt.Errorf("failed to read WIM file %s: %w", wimName, err))
+				return
+			}
+			ok := wimCandidates(ctx, wimName, bundle, search, yield)
+			bundle.Close()
+			if !ok {
+				return
+			}
+		}
+
+		// The parts of a split WIM are only usable together, so they are
+		// handled once the whole ISO has been scanned.
+		for _, key := range splitKeys {
+			if err := ctx.Err(); err != nil {
+				yield(FontCandidate{}, err)
+				return
+			}
+			files := splitSets[key]
+			// Name the set after its first part, install.swm, whose name
+			// has no part number.
+			slices.SortFunc(files, func(a, b isoFile) int {
+				return cmp.Compare(len(a.name), len(b.name))
+			})
+			wimName := files[0].name
+			log.Printf("Processing split WIM file: %s (%d parts)", wimName, len(files))
+			parts := make([]io.ReaderAt, len(files))
+			for i, f := range files {
+				parts[i] = f.data
+			}
+			bundle, err := wim.NewSplitReader(parts...)
+			if err != nil {
+				yield(FontCandidate{}, fmt.Errorf("failed to read split WIM file %s: %w", wimName, err))
+				return
+			}
+			ok := wimCandidates(ctx, wimName, bundle, search, yield)
+			bundle.Close()
+			if !ok {
+				return
+			}
+		}
+	}
+}
+
+// wimCandidates yields the candidates of every image in bundle. It returns
+// false once iteration should stop.
+func wimCandidates(ctx context.Context, wimName string, bundle *wim.Reader, search SearchOptions, yield func(FontCandidate, error) bool) bool {
+	log.Printf("  Found %d image(s) in WIM", len(bundle.Image))
+	for idx, image := range bundle.Image {
+		log.Printf("wimimage: %s", image.Name)
+		log.Printf("  Processing image %d/%d", idx+1, len(bundle.Image))
+		for entry, err := range wimFiles(image, search) {
+			if err == nil {
+				err = ctx.Err()
+			}
+			if err != nil {
+				yield(FontCandidate{}, fmt.Errorf("failed to process image in WIM file %s: %w", wimName, err))
+				return false
+			}
+
+			file := entry.file
+			log.Printf("wimfile: %s", file.Name)
+			if file.IsDir() {
+				continue
+			}
+			if _, ok := formatForName(file.Name); !ok {
+				continue
+			}
+			c := FontCandidate{
+				Name:       file.Name,
+				Path:       entry.path,
+				Wim:        wimName,
+				ImageIndex: idx + 1,
+				ImageName:  image.Name,
+				Size:       file.Size,
+				Open:       file.Open,
+			}
+			// Empty files have no content stream, and a zero hash.
+			if file.Hash != (wim.SHA1Hash{}) {
+				c.SHA1 = hex.EncodeToString(file.Hash[:])
+			}
+			if !yield(c, nil) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// isWimName reports whether name is a WIM file. ESD files, such as the
+// install.esd of media built by the Media Creation Tool, are WIM files using
+// LZMS compressed solid resources.
+func isWimName(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".wim", ".esd":
+		return true
+	}
+	return false
+}
+
+// splitWimSet returns the key shared by the parts of a split WIM: install.swm,
+// install2.swm, install3.swm and so on all map to "sources/install". ok is
+// false for files that are not split WIM parts.
+func splitWimSet(name string) (key string, ok bool) {
+	ext := filepath.Ext(name)
+	if !strings.EqualFold(ext, ".swm") {
+		return "", false
+	}
+	stem := strings.TrimRightFunc(strings.TrimSuffix(name, ext), func(r rune) bool {
+		return r >= '0' && r <= '9'
+	})
+	return strings.ToLower(stem), true
+}
+
+// wimEntry is a file found in a WIM image along with its slash-separated path
+// relative to the image root.
+type wimEntry struct {
+	path string
+	file *wim.File
+}
+
+func wimFiles(image *wim.Image, search SearchOptions) iter.Seq2[wimEntry, error] {
+	return func(yield func(wimEntry, error) bool) {
+		var walk func(string, *wim.File) bool
+
+		walk = func(dir string, f *wim.File) bool {
+			entries, err := f.Readdir()
+			if err != nil {
+				yield(wimEntry{}, fmt.Errorf("failed to read directory %s: %w", dir, err))
+				return false
+			}
+
+			for _, entry := range entries {
+				p := path.Join(dir, entry.Name)
+
+				if !yield(wimEntry{path: p, file: entry}, nil) {
+					return false
+				}
+
+				if entry.IsDir() {
+					if !walk(p, entry) {
+						return false
+					}
+				}
+			}
+			return true
+		}
+
+		root, err := image.Open()
+		if err != nil {
+			yield(wimEntry{}, fmt.Errorf("failed to open WIM image: %w", err))
+			return
+		}
+
+		if search.FullWalk {
+			walk("", root)
+			return
+		}
+
+		for _, searchRoot := range search.Roots {
+			dirPath, dir, err := lookupWimPath(root, searchRoot)
+			if err != nil {
+				yield(wimEntry{}, err)
+				return
+			}
+			if dir == nil {
+				log.Printf("  %s not found in image", searchRoot)
+				continue
+			}
+			if !walk(dirPath, dir) {
+				return
+			}
+		}
+	}
+}
+
+// lookupWimPath resolves name below root one path component at a time, so only
+// the directories along the way are read. It returns a nil file when name does
+// not exist or is not a directory.
+func lookupWimPath(root *wim.File, name string) (string, *wim.File, error) {
+	dir := root
+	var dirPath string
+	for _, component := range strings.Split(strings.ReplaceAll(name, `\`, "/"), "/") {
+		if component == "" || component == "." {
+			continue
+		}
+		entries, err := dir.Readdir()
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to read directory %s: %w", dirPath, err)
+		}
+		var next *wim.File
+		for _, entry := range entries {
+			if entry.IsDir() && strings.EqualFold(entry.Name, component) {
+				next = entry
+				break
+			}
+		}
+		if next == nil {
+			return "", nil, nil
+		}
+		dir = next
+		dirPath = path.Join(dirPath, next.Name)
+	}
+	return dirPath, dir, nil
+}
@@ -0,0 +1,333 @@
+package winfonts
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"iter"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+// wimImage is an image of a test WIM, holding files by slash-separated path.
+type wimImage struct {
+	name  string
+	files map[string]string
+}
+
+// wimDir is a directory of a test WIM image.
+type wimDir struct {
+	files map[string]string
+	dirs  map[string]*wimDir
+}
+
+func newWimDir(files map[string]string) *wimDir {
+	root := &wimDir{files: map[string]string{}, dirs: map[string]*wimDir{}}
+	for p, data := range files {
+		d := root
+		dirs := strings.Split(p, "/")
+		for _, name := range dirs[:len(dirs)-1] {
+			if d.dirs[name] == nil {
+				d.dirs[name] = &wimDir{files: map[string]string{}, dirs: map[string]*wimDir{}}
+			}
+			d = d.dirs[name]
+		}
+		d.files[dirs[len(dirs)-1]] = data
+	}
+	return root
+}
+
+const (
+	wimAttrDirectory = 0x10
+	wimAttrNormal    = 0x80
+)
+
+// wimHash returns the hash a WIM stores for data, which is zero for empty
+// files.
+func wimHash(data string) [20]byte {
+	if data == "" {
+		return [20]byte{}
+	}
+	return sha1.Sum([]byte(data))
+}
+
+// wimDirEntry encodes a directory entry of image metadata.
+func wimDirEntry(attributes uint32, subdir int64, hash [20]byte, name string) []byte {
+	names := utf16.Encode([]rune(name))
+	const fixedSize = 102 // the length prefix and the fixed fields
+	length := (fixedSize + 2*len(names) + 2 + 7) &^ 7
+	entry := make([]byte, length)
+	binary.LittleEndian.PutUint64(entry, uint64(length))
+	binary.LittleEndian.PutUint32(entry[8:], attributes)
+	binary.LittleEndian.PutUint64(entry[16:], uint64(subdir))
+	copy(entry[64:], hash[:])
+	binary.LittleEndian.PutUint16(entry[100:], uint16(2*len(names)))
+	for i, u := range names {
+		binary.LittleEndian.PutUint16(entry[fixedSize+2*i:], u)
+	}
+	return entry
+}
+
+// wimMetadata encodes the metadata of an image holding files.
+func wimMetadata(files map[string]string) []byte {
+	meta := binary.LittleEndian.AppendUint32(nil, 8) // empty security table
+	meta = binary.LittleEndian.AppendUint32(meta, 0)
+
+	// listDir appends the entries of d and returns their offset.
+	var listDir func(d *wimDir) int64
+	listDir = func(d *wimDir) int64 {
+		offset := len(meta)
+		size := 8
+		for name := range d.dirs {
+			size += len(wimDirEntry(0, 0, [20]byte{}, name))
+		}
+		for name := range d.files {
+			size += len(wimDirEntry(0, 0, [20]byte{}, name))
+		}
+		meta = append(meta, make([]byte, size)...)
+		pos := offset
+		for _, name := range slices.Sorted(maps.Keys(d.dirs)) {
+			entry := wimDirEntry(wimAttrDirectory, listDir(d.dirs[name]), [20]byte{}, name)
+			pos += copy(meta[pos:], entry)
+		}
+		for _, name := range slices.Sorted(maps.Keys(d.files)) {
+			entry := wimDirEntry(wimAttrNormal, 0, wimHash(d.files[name]), name)
+			pos += copy(meta[pos:], entry)
+		}
+		return int64(offset)
+	}
+	root := &wimDir{dirs: map[string]*wimDir{"": newWimDir(files)}}
+	listDir(root)
+	return meta
+}
+
+// buildWIM returns the parts of an uncompressed WIM holding images, split
+// into total parts. The metadata and XML data are in the first part, and the
+// file data is spread over the others.
+func buildWIM(total int, images ...wimImage) [][]byte {
+	const headerSize = 208
+	type blob struct {
+		data     []byte
+		metadata bool
+		part     int
+		offset   int
+	}
+	var blobs []*blob
+	xml := `<WIM>`
+	for i, image := range images {
+		blobs = append(blobs, &blob{data: wimMetadata(image.files), metadata: true, part: 1})
+		xml += fmt.Sprintf(`<IMAGE INDEX="%d"><NAME>%s</NAME></IMAGE>`, i+1, image.name)
+	}
+	seen := make(map[[20]byte]bool)
+	for _, image := range images {
+		for _, p := range slices.Sorted(maps.Keys(image.files)) {
+			data := image.files[p]
+			if hash := wimHash(data); hash != [20]byte{} && !seen[hash] {
+				seen[hash] = true
+				part := 1
+				if total > 1 {
+					part = 2 + len(seen)%(total-1)
+				}
+				blobs = append(blobs, &blob{data: []byte(data), part: part})
+			}
+		}
+	}
+	xmlData := binary.LittleEndian.AppendUint16(nil, 0xfeff)
+	for _, u := range utf16.Encode([]rune(xml + `</WIM>`)) {
+		xmlData = binary.LittleEndian.AppendUint16(xmlData, u)
+	}
+
+	resource := func(b []byte, size, offset int, flags byte) []byte {
+		b = binary.LittleEndian.AppendUint64(b, uint64(flags)<<56|uint64(size))
+		b = binary.LittleEndian.AppendUint64(b, uint64(offset))
+		return binary.LittleEndian.AppendUint64(b, uint64(size))
+	}
+	var parts [][]byte
+	for n := 1; n <= total; n++ {
+		body := make([]byte, headerSize)
+		var table []byte
+		for _, b := range blobs {
+			if b.part != n {
+				continue
+			}
+			b.offset = len(body)
+			body = append(body, b.data...)
+			flags := byte(0)
+			if b.metadata {
+				flags = 2
+			}
+			table = resource(table, len(b.data), b.offset, flags)
+			table = binary.LittleEndian.AppendUint16(table, uint16(n))
+			table = binary.LittleEndian.AppendUint32(table, 1)
+			hash := sha1.Sum(b.data)
+			table = append(table, hash[:]...)
+		}
+
+		hdr := append([]byte("MSWIM\x00\x00\x00"), make([]byte, headerSize-8)...)
+		binary.LittleEndian.PutUint32(hdr[8:], headerSize)
+		binary.LittleEndian.PutUint32(hdr[12:], 0x10d00)
+		if total > 1 {
+			binary.LittleEndian.PutUint32(hdr[16:], 8) // spanned
+		}
+		binary.LittleEndian.PutUint32(hdr[24:], 0x12345678) // GUID
+		binary.LittleEndian.PutUint16(hdr[40:], uint16(n))
+		binary.LittleEndian.PutUint16(hdr[42:], uint16(total))
+		binary.LittleEndian.PutUint32(hdr[44:], uint32(len(images)))
+		copy(hdr[48:], resource(nil, len(table), len(body), 0))
+		body = append(body, table...)
+		if n == 1 {
+			copy(hdr[72:], resource(nil, len(xmlData), len(body), 0))
+			body = append(body, xmlData...)
+		}
+		copy(body, hdr)
+		parts = append(parts, body)
+	}
+	return parts
+}
+
+// memFS is an ISO filesystem holding files.
+type memFS map[string][]byte
+
+func (fs memFS) files() iter.Seq2[isoFile, error] {
+	return func(yield func(isoFile, error) bool) {
+		for _, p := range slices.Sorted(maps.Keys(fs)) {
+			f := isoFile{path: p, name: path.Base(p), size: int64(len(fs[p])), data: bytes.NewReader(fs[p])}
+			if !yield(f, nil) {
+				return
+			}
+		}
+	}
+}
+
+// isoCandidates returns the candidates found in fs, as "wim:index:path".
+func isoCandidates(t *testing.T, fs isoFS, roots ...string) []string {
+	t.Helper()
+	var got []string
+	for c, err := range (isoSource{fs}).Candidates(context.Background(), SearchOptions{Roots: roots}) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fmt.Sprintf("%s:%d:%s", c.Wim, c.ImageIndex, c.Path))
+	}
+	return got
+}
+
+var testImage = wimImage{name: "Windows 11 Pro", files: map[string]string{
+	"Windows/Fonts/arial.ttf":   "arial",
+	"Windows/Fonts/readme.txt":  "not a font",
+	"Windows/System32/cour.ttf": "outside of the search root",
+}}
+
+func TestISOSourceFindsWims(t *testing.T) {
+	wimData := buildWIM(1, testImage)[0]
+	split := buildWIM(3, testImage)
+	for _, tt := range []struct {
+		name string
+		fs   memFS
+		want []string
+	}{
+		{"install.wim", memFS{"sources/install.wim": wimData}, []string{"install.wim:1:Windows/Fonts/arial.ttf"}},
+		{"upper case", memFS{"SOURCES/INSTALL.WIM": wimData}, []string{"INSTALL.WIM:1:Windows/Fonts/arial.ttf"}},
+		{"install.esd", memFS{"sources/install.esd": wimData}, []string{"install.esd:1:Windows/Fonts/arial.ttf"}},
+		{"split", memFS{
+			"sources/install2.swm": split[1],
+			"sources/install.swm":  split[0],
+			"sources/install3.swm": split[2],
+		}, []string{"install.swm:1:Windows/Fonts/arial.ttf"}},
+		{"no WIM", memFS{
+			"sources/boot.sdi":   []byte("boot"),
+			"sources/readme.txt": []byte("no WIM here"),
+		}, nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got := isoCandidates(t, tt.fs, DefaultSearchRoots...)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got candidates %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestISOSourceSplitWimMissingPart(t *testing.T) {
+	split := buildWIM(3, testImage)
+	fs := memFS{"sources/install.swm": split[0], "sources/install3.swm": split[2]}
+	for _, err := range (isoSource{fs}).Candidates(context.Background(), SearchOptions{Roots: DefaultSearchRoots}) {
+		if err == nil || !strings.Contains(err.Error(), "install.swm") {
+			t.Errorf("got error %v, want one naming install.swm", err)
+		}
+	}
+}
+
+func TestLookupWimPath(t *testing.T) {
+	image := wimImage{name: "Windows 11 Pro", files: map[string]string{
+		"WINDOWS/fonts/Arial.ttf": "arial",
+		"Windows/Fonts.txt":       "a file",
+		"Program Files/x/y.otf":   "other",
+	}}
+	fs := memFS{"sources/install.wim": buildWIM(1, image)[0]}
+	for _, tt := range []struct {
+		roots []string
+		want  []string
+	}{
+		{[]string{"Windows/Fonts"}, []string{"install.wim:1:WINDOWS/fonts/Arial.ttf"}},
+		{[]string{`\windows\FONTS\`}, []string{"install.wim:1:WINDOWS/fonts/Arial.ttf"}},
+		{[]string{"Windows/Fonts.txt"}, nil},
+		{[]string{"Windows/Fonts/Arial.ttf"}, nil},
+		{[]string{"Missing/Fonts"}, nil},
+		{[]string{"Missing", "program files"}, []string{"install.wim:1:Program Files/x/y.otf"}},
+	} {
+		if got := isoCandidates(t, fs, tt.roots...); !slices.Equal(got, tt.want) {
+			t.Errorf("roots %q: got candidates %q, want %q", tt.roots, got, tt.want)
+		}
+	}
+}
+
+func TestWimCandidatesSHA1(t *testing.T) {
+	image := wimImage{name: "Windows 11 Pro", files: map[string]string{
+		"Windows/Fonts/arial.ttf": "arial",
+		"Windows/Fonts/empty.ttf": "",
+	}}
+	fs := memFS{"sources/install.wim": buildWIM(1, image)[0]}
+	sums := make(map[string]string)
+	for c, err := range (isoSource{fs}).Candidates(context.Background(), SearchOptions{Roots: DefaultSearchRoots}) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		sums[c.Name] = c.SHA1
+	}
+	arial := sha1.Sum([]byte("arial"))
+	if got, want := sums["arial.ttf"], fmt.Sprintf("%x", arial); got != want {
+		t.Errorf("arial.ttf has SHA1 %q, want %q", got, want)
+	}
+	if got, ok := sums["empty.ttf"]; !ok || got != "" {
+		t.Errorf("empty.ttf has SHA1 %q, want none", got)
+	}
+}
+
+func TestSplitWimSet(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		key  string
+		ok   bool
+	}{
+		{"sources/install.swm", "sources/install", true},
+		{"sources/install2.swm", "sources/install", true},
+		{"SOURCES/INSTALL12.SWM", "sources/install", true},
+		{"sources/boot.swm", "sources/boot", true},
+		{"other/install2.swm", "other/install", true},
+		{"sources/install.wim", "", false},
+		{"sources/install.esd", "", false},
+		{"sources/install2", "", false},
+	} {
+		key, ok := splitWimSet(tt.name)
+		if key != tt.key || ok != tt.ok {
+			t.Errorf("splitWimSet(%q) = %q, %v, want %q, %v", tt.name, key, ok, tt.key, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package winfonts
+
+import (
+	"fmt"
+	"strings"
+)
+
+// skuLanguages maps locales to the names the download connector gives the
+// languages of its SKUs.
+var skuLanguages = map[Language]string{
+	"ar-SA":      "Arabic",
+	"bg-BG":      "Bulgarian",
+	"cs-CZ":      "Czech",
+	"da-DK":      "Danish",
+	"de-DE":      "German",
+	"el-GR":      "Greek",
+	"en-GB":      "English International",
+	"en-US":      "English",
+	"es-ES":      "Spanish",
+	"es-MX":      "Spanish (Mexico)",
+	"et-EE":      "Estonian",
+	"fi-FI":      "Finnish",
+	"fr-CA":      "French Canadian",
+	"fr-FR":      "French",
+	"he-IL":      "Hebrew",
+	"hr-HR":      "Croatian",
+	"hu-HU":      "Hungarian",
+	"it-IT":      "Italian",
+	"ja-JP":      "Japanese",
+	"ko-KR":      "Korean",
+	"lt-LT":      "Lithuanian",
+	"lv-LV":      "Latvian",
+	"nb-NO":      "Norwegian",
+	"nl-NL":      "Dutch",
+	"pl-PL":      "Polish",
+	"pt-BR":      "Brazilian Portuguese",
+	"pt-PT":      "Portuguese",
+	"ro-RO":      "Romanian",
+	"ru-RU":      "Russian",
+	"sk-SK":      "Slovak",
+	"sl-SI":      "Slovenian",
+	"sr-Latn-RS": "Serbian Latin",
+	"sv-SE":      "Swedish",
+	"th-TH":      "Thai",
+	"tr-TR":      "Turkish",
+	"uk-UA":      "Ukrainian",
+	"zh-CN":      "Chinese Simplified",
+	"zh-TW":      "Chinese Traditional",
+}
+
+// locale returns the locale to send the download connector for language,
+// which may also be the English name of a language. Other names, which the
+// connector does not understand, are sent as en-US; the SKU is still
+// selected by the name.
+func locale(language Language) Language {
+	if _, ok := skuLanguages[language]; ok {
+		return language
+	}
+	for l, name := range skuLanguages {
+		if strings.EqualFold(name, string(language)) {
+			return l
+		}
+	}
+	return LanguageEnglishUS
+}
+
+// acceptLanguage returns the Accept-Language header a browser set to the
+// locale of language would send, such as "pt-BR,pt;q=0.9".
+func acceptLanguage(language Language) string {
+	l := string(locale(language))
+	primary, _, _ := strings.Cut(l, "-")
+	return l + "," + primary + ";q=0.9"
+}
+
+// LanguageError is returned when a product edition has no SKU in the
+// requested language. It matches ErrNoSKU.
+type LanguageError struct {
+	Language  Language
+	Available []string
+}
+
+func (e *LanguageError) Error() string {
+	return fmt.Sprintf("%v for language %s; available languages: %s", ErrNoSKU, e.Language, strings.Join(e.Available, ", "))
+}
+
+func (e *LanguageError) Unwrap() error { return ErrNoSKU }
+
+// matchesLanguage reports whether sku is in language, given either as a
+// locale such as pt-BR or as the name of the language, in English or in the
+// language itself.
+func (sku *SKUInfo) matchesLanguage(language Language) bool {
+	names := []string{string(language)}
+	if name, ok := skuLanguages[language]; ok {
+		names = append(names, name)
+	}
+	for _, name := range names {
+		if strings.EqualFold(sku.Language, name) || strings.EqualFold(sku.LocalizedLanguage, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// selectSKU returns the SKU in language.
+func selectSKU(skus []SKUInfo, language Language) (*SKUInfo, error) {
+	for i := range skus {
+		if skus[i].matchesLanguage(language) {
+			return &skus[i], nil
+		}
+	}
+	available := make([]string, len(skus))
+	for i, sku := range skus {
+		available[i] = sku.Language
+	}
+	return nil, &LanguageError{Language: language, Available: available}
+}
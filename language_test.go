@@ -0,0 +1,82 @@
+package winfonts
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestSelectSKU(t *testing.T) {
+	skus := []SKUInfo{
+		{ID: "1", Language: "English International", LocalizedLanguage: "English International"},
+		{ID: "2", Language: "Brazilian Portuguese", LocalizedLanguage: "Português (Brasil)"},
+		{ID: "3", Language: "English", LocalizedLanguage: "English (United States)"},
+	}
+	for _, tt := range []struct {
+		language Language
+		id       string
+	}{
+		{"en-US", "3"},
+		{"en-GB", "1"},
+		{"pt-BR", "2"},
+		{"english international", "1"},
+		{"Português (Brasil)", "2"},
+	} {
+		sku, err := selectSKU(skus, tt.language)
+		if err != nil {
+			t.Errorf("selectSKU(%s): %v", tt.language, err)
+		} else if sku.ID != tt.id {
+			t.Errorf("selectSKU(%s) = SKU %s, want %s", tt.language, sku.ID, tt.id)
+		}
+	}
+
+	_, err := selectSKU(skus, "ja-JP")
+	var langErr *LanguageError
+	if !errors.Is(err, ErrNoSKU) || !errors.As(err, &langErr) || len(langErr.Available) != 3 {
+		t.Errorf("selectSKU(ja-JP) = %v, want a LanguageError listing 3 languages", err)
+	}
+}
+
+func TestLocaleParam(t *testing.T) {
+	for _, tt := range []struct {
+		language       Language
+		locale         string
+		acceptLanguage string
+	}{
+		{"pt-BR", "pt-BR", "pt-BR,pt;q=0.9"},
+		{"English International", "en-GB", "en-GB,en;q=0.9"},
+		{"brazilian portuguese", "pt-BR", "pt-BR,pt;q=0.9"},
+		{"sr-Latn-RS", "sr-Latn-RS", "sr-Latn-RS,sr;q=0.9"},
+		{"Português (Brasil)", "en-US", "en-US,en;q=0.9"},
+	} {
+		s := newConnectorServer(t)
+		var skuLocale, linkLocale string
+		checkHeader := func(r *http.Request) {
+			if got := r.Header.Get("Accept-Language"); got != tt.acceptLanguage {
+				t.Errorf("language %s sent with Accept-Language %q, want %q", tt.language, got, tt.acceptLanguage)
+			}
+		}
+		s.skuFail = func(w http.ResponseWriter, r *http.Request, n int) bool {
+			skuLocale = r.URL.Query().Get("Locale")
+			checkHeader(r)
+			return false
+		}
+		s.linkFail = func(w http.ResponseWriter, r *http.Request, n int) bool {
+			linkLocale = r.URL.Query().Get("Locale")
+			checkHeader(r)
+			return false
+		}
+		w := s.downloader(ArchX64, fastRetries)
+		w.language = tt.language
+		if _, err := w.SKUs(context.Background(), "2618"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.DownloadOptions(context.Background(), "42"); err != nil {
+			t.Fatal(err)
+		}
+		if skuLocale != tt.locale || linkLocale != tt.locale {
+			t.Errorf("language %s sent as Locale %q and %q, want %q", tt.language, skuLocale, linkLocale, tt.locale)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package winfonts
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// ManifestName is the name of the manifest written to the output directory.
+const ManifestName = "fonts.json"
+
+// Manifest is the machine-readable record of an extraction. Fonts are sorted
+// by output path so that manifests of unchanged media compare equal.
+type Manifest struct {
+	Fonts     []*ExtractedFont   `json:"fonts"`
+	Conflicts []ManifestConflict `json:"conflicts,omitempty"`
+}
+
+// ManifestConflict lists the output files holding the distinct versions of a
+// file name found more than once.
+type ManifestConflict struct {
+	Name  string   `json:"name"`
+	Files []string `json:"files"`
+}
+
+// Manifest builds the manifest for the last call to Run.
+func (e *FontExtractor) Manifest() *Manifest {
+	m := &Manifest{
+		Fonts: append([]*ExtractedFont{}, e.Fonts()...),
+	}
+	sort.Slice(m.Fonts, func(i, j int) bool {
+		return m.Fonts[i].Name < m.Fonts[j].Name
+	})
+	for _, conflict := range e.Conflicts() {
+		mc := ManifestConflict{Name: conflict.Name}
+		for _, font := range conflict.Fonts {
+			mc.Files = append(mc.Files, font.Name)
+		}
+		m.Conflicts = append(m.Conflicts, mc)
+	}
+	return m
+}
+
+// ReadManifest loads a manifest previously written by an extraction.
+func ReadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest %s: %w", path, err)
+	}
+	return &m, nil
+}
+
+func (e *FontExtractor) writeManifest() error {
+	data, err := json.MarshalIndent(e.Manifest(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	data = append(data, '\n')
+	if err := e.sink.WriteFile(ManifestName, bytes.NewReader(data), int64(len(data))); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return nil
+}
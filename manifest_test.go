@@ -0,0 +1,40 @@
+package winfonts
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestManifestGolden(t *testing.T) {
+	src := memSource{
+		memCandidate("sources/install.wim", 1, "Windows/Fonts/times.ttf", testFont(1)),
+		memCandidate("sources/install.wim", 1, "Windows/Fonts/arial.ttf", sfntFace(0, testFace("Test Sans"))),
+		memCandidate("sources/install.wim", 2, "Windows/Fonts/ARIAL.TTF", testFont(2)),
+		memCandidate("", 0, "extra/times.ttf", testFont(1)),
+	}
+	src[0].ImageName, src[1].ImageName = "Windows 11 Home", "Windows 11 Home"
+	src[2].ImageName = "Windows 11 Pro"
+	e, output := runExtractor(t, src)
+	got, err := os.ReadFile(filepath.Join(output, ManifestName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile(filepath.Join("testdata", ManifestName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("got manifest\n%s\nwant\n%s", got, want)
+	}
+
+	m, err := ReadManifest(filepath.Join(output, ManifestName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m, e.Manifest()) {
+		t.Errorf("read back manifest %+v, want %+v", m, e.Manifest())
+	}
+}
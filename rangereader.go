@@ -0,0 +1,283 @@
+package winfonts
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrRangeUnsupported is returned by NewRangeReader when the server does not
+// answer Range requests with partial content.
+var ErrRangeUnsupported = errors.New("server does not support range requests")
+
+const (
+	defaultRangeBlockSize = 1 << 20
+	defaultRangeCacheSize = 64 << 20
+	defaultRangeReadAhead = 8
+)
+
+// RangeReader is an io.ReaderAt over a remote file, read with HTTP Range
+// requests. It lets an ISO be opened without downloading it: the UDF and WIM
+// readers only touch the filesystem metadata, the WIM metadata and the
+// resources of the fonts, a small part of the image.
+//
+// Data is fetched in blocks, which are kept in a least recently used cache.
+// When reads move forward through the file, as they do when a font is copied,
+// the blocks that follow are fetched in the same request. Requests are issued
+// one at a time, and are cancelled with the context given to NewRangeReader.
+type RangeReader struct {
+	ctx       context.Context
+	client    *http.Client
+	url       string
+	validator string // If-Range value, if the file has one usable for ranges
+	size      int64
+	blockSize int64
+	cacheSize int64
+	readAhead int64
+
+	m       sync.Mutex
+	entries map[int64]*list.Element
+	lru     list.List
+	last    int64 // last block fetched, for detecting sequential reads
+	fetched int64
+}
+
+type rangeBlock struct {
+	index int64
+	data  []byte
+}
+
+// RangeReaderOption configures optional behaviour of a RangeReader.
+type RangeReaderOption func(*RangeReader)
+
+// WithBlockSize sets the size of the blocks a RangeReader fetches and caches.
+// It defaults to 1 MiB.
+func WithBlockSize(size int64) RangeReaderOption {
+	return func(r *RangeReader) {
+		if size > 0 {
+			r.blockSize = size
+		}
+	}
+}
+
+// WithCacheSize sets how many bytes of blocks a RangeReader keeps. It defaults
+// to 64 MiB.
+func WithCacheSize(size int64) RangeReaderOption {
+	return func(r *RangeReader) {
+		r.cacheSize = size
+	}
+}
+
+// WithReadAhead sets how many blocks after the ones being read a RangeReader
+// fetches when reads are sequential. It defaults to 8; 0 disables read-ahead.
+func WithReadAhead(blocks int) RangeReaderOption {
+	return func(r *RangeReader) {
+		if blocks >= 0 {
+			r.readAhead = int64(blocks)
+		}
+	}
+}
+
+// NewRangeReader returns a RangeReader for url. It issues a first request to
+// learn the size of the file and check that the server supports Range
+// requests. A nil client means http.DefaultClient.
+func NewRangeReader(ctx context.Context, client *http.Client, url string, opts ...RangeReaderOption) (*RangeReader, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	r := &RangeReader{
+		ctx:       ctx,
+		client:    client,
+		url:       url,
+		blockSize: defaultRangeBlockSize,
+		cacheSize: defaultRangeCacheSize,
+		readAhead: defaultRangeReadAhead,
+		entries:   make(map[int64]*list.Element),
+		last:      -1,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	resp, err := r.get(0, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil, ErrRangeUnsupported
+	}
+	_, _, size, err := parseContentRange(resp.Header.Get("Content-Range"))
+	if err != nil {
+		return nil, err
+	}
+	if size < 0 {
+		return nil, errors.New("server did not report the size of the file")
+	}
+	r.size = size
+	r.validator = ifRangeValidator(resp.Header.Get("ETag"), resp.Header.Get("Last-Modified"))
+	return r, nil
+}
+
+// Size returns the size of the remote file.
+func (r *RangeReader) Size() int64 {
+	return r.size
+}
+
+// Fetched returns how many bytes have been downloaded so far.
+func (r *RangeReader) Fetched() int64 {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return r.fetched
+}
+
+func (r *RangeReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	end := min(off+int64(len(p)), r.size)
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	n := 0
+	for pos := off; pos < end; {
+		index := pos / r.blockSize
+		data, err := r.block(index, (end-1)/r.blockSize)
+		if err != nil {
+			return n, err
+		}
+		k := copy(p[n:], data[pos-index*r.blockSize:])
+		n += k
+		pos += int64(k)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// block returns block index, fetching it if it is not cached. Blocks up to
+// last are needed by the current read and are fetched along with it.
+func (r *RangeReader) block(index, last int64) ([]byte, error) {
+	if e, ok := r.entries[index]; ok {
+		r.lru.MoveToFront(e)
+		return e.Value.(*rangeBlock).data, nil
+	}
+
+	if index == r.last+1 {
+		last = max(last, index+r.readAhead)
+	}
+	last = min(last, (r.size-1)/r.blockSize)
+	// Blocks already cached need not be fetched again.
+	for i := index + 1; i <= last; i++ {
+		if _, ok := r.entries[i]; ok {
+			last = i - 1
+			break
+		}
+	}
+
+	start := index * r.blockSize
+	data, err := r.fetch(start, min((last+1)*r.blockSize, r.size)-1)
+	if err != nil {
+		return nil, err
+	}
+	r.fetched += int64(len(data))
+	r.last = last
+	// Add the requested block last, so that it is the one kept should
+	// the cache be too small for all of them.
+	for i := last; i >= index; i-- {
+		off := (i - index) * r.blockSize
+		r.add(i, data[off:min(off+r.blockSize, int64(len(data)))])
+	}
+	return r.entries[index].Value.(*rangeBlock).data, nil
+}
+
+func (r *RangeReader) add(index int64, data []byte) {
+	r.entries[index] = r.lru.PushFront(&rangeBlock{index, data})
+	for int64(r.lru.Len())*r.blockSize > r.cacheSize && r.lru.Len() > 1 {
+		e := r.lru.Back()
+		block := r.lru.Remove(e).(*rangeBlock)
+		delete(r.entries, block.index)
+	}
+}
+
+// fetch downloads the bytes from start to end inclusive.
+func (r *RangeReader) fetch(start, end int64) ([]byte, error) {
+	resp, err := r.get(start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		// Only sent when If-Range no longer matches.
+		return nil, fmt.Errorf("remote file %s has changed", r.url)
+	default:
+		return nil, fmt.Errorf("range request failed with status %s", resp.Status)
+	}
+	first, _, _, err := parseContentRange(resp.Header.Get("Content-Range"))
+	if err != nil {
+		return nil, err
+	}
+	if first != start {
+		return nil, fmt.Errorf("server returned range starting at %d instead of %d", first, start)
+	}
+	data := make([]byte, end-start+1)
+	if _, err := io.ReadFull(resp.Body, data); err != nil {
+		return nil, fmt.Errorf("failed to read range %d-%d: %w", start, end, err)
+	}
+	return data, nil
+}
+
+func (r *RangeReader) get(start, end int64) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(r.ctx, http.MethodGet, r.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	if r.validator != "" {
+		req.Header.Set("If-Range", r.validator)
+	}
+	return r.client.Do(req)
+}
+
+// parseContentRange parses a Content-Range header such as
+// "bytes 0-1023/4096". size is -1 when the server does not know it.
+func parseContentRange(s string) (first, last, size int64, err error) {
+	spec, ok := strings.CutPrefix(s, "bytes ")
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("invalid Content-Range %q", s)
+	}
+	rng, total, ok := strings.Cut(spec, "/")
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("invalid Content-Range %q", s)
+	}
+	a, b, ok := strings.Cut(rng, "-")
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("invalid Content-Range %q", s)
+	}
+	if first, err = strconv.ParseInt(a, 10, 64); err == nil {
+		last, err = strconv.ParseInt(b, 10, 64)
+	}
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid Content-Range %q", s)
+	}
+	size = -1
+	if total != "*" {
+		if size, err = strconv.ParseInt(total, 10, 64); err != nil {
+			return 0, 0, 0, fmt.Errorf("invalid Content-Range %q", s)
+		}
+	}
+	return first, last, size, nil
+}
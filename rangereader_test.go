@@ -0,0 +1,185 @@
+package winfonts
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// rangeServer serves data with Range support and counts the requests made.
+func rangeServer(t *testing.T, data []byte) (*httptest.Server, *atomic.Int64) {
+	var requests atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests.Add(1)
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, req, "image.iso", time.Time{}, bytes.NewReader(data))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &requests
+}
+
+func testData(size int) []byte {
+	data := make([]byte, size)
+	rng := rand.New(rand.NewPCG(1, 2))
+	for i := range data {
+		data[i] = byte(rng.Uint32())
+	}
+	return data
+}
+
+func TestRangeReaderReadAt(t *testing.T) {
+	data := testData(10*1000 + 37)
+	srv, _ := rangeServer(t, data)
+
+	r, err := NewRangeReader(context.Background(), srv.Client(), srv.URL,
+		WithBlockSize(1000), WithCacheSize(3000), WithReadAhead(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Size() != int64(len(data)) {
+		t.Fatalf("Size() = %d, want %d", r.Size(), len(data))
+	}
+
+	rng := rand.New(rand.NewPCG(3, 4))
+	for range 200 {
+		off := rng.Int64N(int64(len(data)))
+		p := make([]byte, rng.IntN(2500)+1)
+		n, err := r.ReadAt(p, off)
+		want := data[off:min(off+int64(len(p)), int64(len(data)))]
+		if n != len(want) || !bytes.Equal(p[:n], want) {
+			t.Fatalf("ReadAt(%d bytes, %d) returned wrong data", len(p), off)
+		}
+		if n < len(p) && err != io.EOF {
+			t.Fatalf("short ReadAt(%d bytes, %d) returned %v, want io.EOF", len(p), off, err)
+		}
+		if n == len(p) && err != nil {
+			t.Fatalf("ReadAt(%d bytes, %d): %v", len(p), off, err)
+		}
+	}
+
+	if _, err := r.ReadAt(make([]byte, 1), int64(len(data))); err != io.EOF {
+		t.Errorf("ReadAt at end of file returned %v, want io.EOF", err)
+	}
+}
+
+func TestRangeReaderCache(t *testing.T) {
+	data := testData(8 * 1000)
+	srv, requests := rangeServer(t, data)
+
+	r, err := NewRangeReader(context.Background(), srv.Client(), srv.URL,
+		WithBlockSize(1000), WithReadAhead(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := make([]byte, 1500)
+	for range 3 {
+		if _, err := r.ReadAt(p, 4200); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// One request to open the file and one for blocks 4 and 5.
+	if got := requests.Load(); got != 2 {
+		t.Errorf("made %d requests, want 2", got)
+	}
+	if got := r.Fetched(); got != 2000 {
+		t.Errorf("fetched %d bytes, want 2000", got)
+	}
+}
+
+func TestRangeReaderReadAhead(t *testing.T) {
+	data := testData(16 * 1000)
+	srv, requests := rangeServer(t, data)
+
+	r, err := NewRangeReader(context.Background(), srv.Client(), srv.URL,
+		WithBlockSize(1000), WithReadAhead(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(io.NewSectionReader(r, 0, r.Size()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("sequential read returned wrong data")
+	}
+	// One request to open the file, then four blocks at a time.
+	if n := requests.Load(); n != 1+4 {
+		t.Errorf("made %d requests, want 5", n)
+	}
+}
+
+func TestRangeReaderUnsupported(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("no ranges here"))
+	}))
+	defer srv.Close()
+
+	_, err := NewRangeReader(context.Background(), srv.Client(), srv.URL)
+	if !errors.Is(err, ErrRangeUnsupported) {
+		t.Errorf("NewRangeReader returned %v, want ErrRangeUnsupported", err)
+	}
+}
+
+func TestRangeReaderChanged(t *testing.T) {
+	data := testData(4000)
+	etag := `"v1"`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, req, "image.iso", time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	r, err := NewRangeReader(context.Background(), srv.Client(), srv.URL, WithBlockSize(1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	etag = `"v2"`
+	if _, err := r.ReadAt(make([]byte, 10), 0); err == nil {
+		t.Error("ReadAt succeeded after the remote file changed")
+	}
+}
+
+func TestRangeReaderWeakETag(t *testing.T) {
+	data := testData(4000)
+	modified := time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		name     string
+		modified time.Time
+		ifRange  string
+	}{
+		{"with Last-Modified", modified, modified.Format(http.TimeFormat)},
+		{"without Last-Modified", time.Time{}, ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var ifRange atomic.Value
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				ifRange.Store(req.Header.Get("If-Range"))
+				w.Header().Set("ETag", `W/"v1"`)
+				http.ServeContent(w, req, "image.iso", tt.modified, bytes.NewReader(data))
+			}))
+			defer srv.Close()
+
+			r, err := NewRangeReader(context.Background(), srv.Client(), srv.URL, WithBlockSize(1000))
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := make([]byte, 1500)
+			if _, err := r.ReadAt(p, 1200); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(p, data[1200:2700]) {
+				t.Error("ReadAt returned wrong data")
+			}
+			if got := ifRange.Load(); got != tt.ifRange {
+				t.Errorf("sent If-Range %q, want %q", got, tt.ifRange)
+			}
+		})
+	}
+}
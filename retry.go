@@ -0,0 +1,111 @@
+package winfonts
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RetryPolicy controls how the requests GetDownloadURL makes to Microsoft are
+// retried when they fail with an error that may not happen again, such as a
+// server error, a dropped connection, rate limiting or a blocked session.
+// Download also waits out its backoff when the download server cannot be
+// reached.
+type RetryPolicy struct {
+	// MaxAttempts is how many times a request is made before giving up.
+	// Values below 1 mean 1.
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry. It doubles with
+	// every retry, up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Jitter is the fraction of each wait, from 0 to 1, that is randomly
+	// taken off, so that clients started together do not retry together.
+	Jitter float64
+}
+
+// DefaultRetryPolicy is the retry policy used unless WithRetryPolicy is given.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    5,
+	InitialBackoff: 2 * time.Second,
+	MaxBackoff:     time.Minute,
+	Jitter:         0.5,
+}
+
+// WithRetryPolicy sets how the requests to Microsoft are retried. A zero
+// RetryPolicy disables retries.
+func WithRetryPolicy(policy RetryPolicy) DownloaderOption {
+	return func(w *WindowsDownloader) {
+		w.retry = policy
+	}
+}
+
+// backoff returns how long to wait before retry n, counting from 1.
+func (p RetryPolicy) backoff(n int) time.Duration {
+	d := p.InitialBackoff
+	for i := 1; i < n && (p.MaxBackoff <= 0 || d < p.MaxBackoff); i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 {
+		d = min(d, p.MaxBackoff)
+	}
+	jitter := min(max(p.Jitter, 0), 1)
+	return d - time.Duration(rand.Float64()*jitter*float64(d))
+}
+
+// withRetries calls step until it succeeds or fails with an error that
+// retrying cannot fix, waiting between attempts as the retry policy and any
+// Retry-After header say. A request Microsoft rejects gets a new session, which
+// step is expected to register with ensureSession.
+func (w *WindowsDownloader) withRetries(ctx context.Context, step func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := step()
+		if err == nil || ctx.Err() != nil || attempt >= w.retry.MaxAttempts || !retryable(err) {
+			return err
+		}
+
+		wait := w.retry.backoff(attempt)
+		var apiErr *APIError
+		if errors.As(err, &apiErr) {
+			wait = max(wait, apiErr.RetryAfter)
+			if apiErr.Err == ErrBlocked || len(apiErr.Errors) > 0 {
+				w.sessionID = uuid.New().String()
+				w.sessionRegistered = false
+			}
+		}
+		log.Printf("%v; retrying in %s", err, wait.Round(time.Millisecond))
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// ensureSession registers the session, unless it already is.
+func (w *WindowsDownloader) ensureSession(ctx context.Context) error {
+	if w.sessionRegistered {
+		return nil
+	}
+	return w.registerSession(ctx)
+}
+
+// retryable reports whether a request that failed with err may succeed if it
+// is made again.
+func retryable(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		// Other errors the connector reports, such as an invalid SKU,
+		// fail the same way every time.
+		return apiErr.Err == ErrBlocked || apiErr.Err == ErrRateLimited ||
+			apiErr.StatusCode >= 500 || apiErr.StatusCode == http.StatusTooManyRequests
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,244 @@
+package winfonts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// connectorServer stands in for the Microsoft download page, session
+// registration and download connector. Handlers for a step can be replaced
+// to make it fail.
+type connectorServer struct {
+	*httptest.Server
+
+	m        sync.Mutex
+	requests map[string]int
+	sessions []string // sessions registered, in order
+	skuFail  func(w http.ResponseWriter, r *http.Request, n int) bool
+	linkFail func(w http.ResponseWriter, r *http.Request, n int) bool
+}
+
+const blockedResponse = `{"ValidationContainer":{"Errors":[{"Key":"ErrorSettings.SentinelReject","Value":"Sentinel marked this request as rejected.","Type":9}]}}`
+
+func newConnectorServer(t *testing.T) *connectorServer {
+	s := &connectorServer{requests: make(map[string]int)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/page/", func(w http.ResponseWriter, r *http.Request) {
+		s.count("page")
+		fmt.Fprint(w, "<table><tr><td>English 64-bit</td><td>"+
+			"0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef</td></tr></table>")
+	})
+	mux.HandleFunc("/tags", func(w http.ResponseWriter, r *http.Request) {
+		s.count("session")
+		s.m.Lock()
+		s.sessions = append(s.sessions, r.URL.Query().Get("session_id"))
+		s.m.Unlock()
+	})
+	mux.HandleFunc("/sku", func(w http.ResponseWriter, r *http.Request) {
+		n := s.count("sku")
+		if s.skuFail != nil && s.skuFail(w, r, n) {
+			return
+		}
+		fmt.Fprint(w, `{"Skus":[{"Id":"42","Language":"English"}]}`)
+	})
+	mux.HandleFunc("/link", func(w http.ResponseWriter, r *http.Request) {
+		n := s.count("link")
+		if s.linkFail != nil && s.linkFail(w, r, n) {
+			return
+		}
+		fmt.Fprint(w, `{"ProductDownloadOptions":[`+
+			`{"DownloadType":0,"Uri":"https://example.com/x86.iso"},`+
+			`{"DownloadType":1,"Uri":"https://example.com/x64.iso"}]}`)
+	})
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+// count records a request for step and returns how many it has had.
+func (s *connectorServer) count(step string) int {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.requests[step]++
+	return s.requests[step]
+}
+
+func (s *connectorServer) downloader(arch Architecture, policy RetryPolicy) *WindowsDownloader {
+	w := NewWindowsDownloader(Windows11, EditionPro, arch, LanguageEnglishUS, WithRetryPolicy(policy))
+	w.endpoints = endpoints{
+		page:     s.URL + "/page/",
+		session:  s.URL + "/tags",
+		sku:      s.URL + "/sku",
+		download: s.URL + "/link",
+	}
+	return w
+}
+
+var fastRetries = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: time.Millisecond,
+	MaxBackoff:     5 * time.Millisecond,
+	Jitter:         0.5,
+}
+
+func TestGetDownloadURL(t *testing.T) {
+	s := newConnectorServer(t)
+	w := s.downloader(ArchX64, fastRetries)
+	url, err := w.GetDownloadURL(context.Background(), "2618")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url != "https://example.com/x64.iso" {
+		t.Errorf("got URL %s", url)
+	}
+	if sum, ok := w.ExpectedSHA256(); !ok || sum[:4] != "0123" {
+		t.Errorf("got expected SHA-256 %q, %v", sum, ok)
+	}
+}
+
+func TestGetDownloadURLRetriesServerErrors(t *testing.T) {
+	s := newConnectorServer(t)
+	s.skuFail = func(w http.ResponseWriter, r *http.Request, n int) bool {
+		if n <= 2 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return true
+		}
+		return false
+	}
+	w := s.downloader(ArchX64, fastRetries)
+	if _, err := w.GetDownloadURL(context.Background(), "2618"); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.requests["sku"]; got != 3 {
+		t.Errorf("made %d SKU requests, want 3", got)
+	}
+}
+
+func TestGetDownloadURLGivesUp(t *testing.T) {
+	s := newConnectorServer(t)
+	s.linkFail = func(w http.ResponseWriter, r *http.Request, n int) bool {
+		http.Error(w, "unavailable", http.StatusBadGateway)
+		return true
+	}
+	w := s.downloader(ArchX64, fastRetries)
+	_, err := w.GetDownloadURL(context.Background(), "2618")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadGateway {
+		t.Fatalf("got error %v, want an APIError with status 502", err)
+	}
+	if got := s.requests["link"]; got != fastRetries.MaxAttempts {
+		t.Errorf("made %d download link requests, want %d", got, fastRetries.MaxAttempts)
+	}
+}
+
+func TestGetDownloadURLHonoursRetryAfter(t *testing.T) {
+	s := newConnectorServer(t)
+	s.skuFail = func(w http.ResponseWriter, r *http.Request, n int) bool {
+		if n == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return true
+		}
+		return false
+	}
+	w := s.downloader(ArchX64, fastRetries)
+	start := time.Now()
+	if _, err := w.GetDownloadURL(context.Background(), "2618"); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %s, before Retry-After", elapsed)
+	}
+}
+
+func TestGetDownloadURLRenewsBlockedSession(t *testing.T) {
+	s := newConnectorServer(t)
+	var blocked string
+	s.skuFail = func(w http.ResponseWriter, r *http.Request, n int) bool {
+		if n == 1 {
+			blocked = r.URL.Query().Get("sessionID")
+			fmt.Fprint(w, blockedResponse)
+			return true
+		}
+		if got := r.URL.Query().Get("sessionID"); got == blocked {
+			t.Errorf("retried with the blocked session %s", got)
+		}
+		return false
+	}
+	w := s.downloader(ArchX64, fastRetries)
+	if _, err := w.GetDownloadURL(context.Background(), "2618"); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.sessions) != 2 || s.sessions[0] != blocked || s.sessions[1] == blocked {
+		t.Errorf("registered sessions %v, want %s then a new one", s.sessions, blocked)
+	}
+}
+
+func TestGetDownloadURLPermanentConnectorError(t *testing.T) {
+	s := newConnectorServer(t)
+	s.skuFail = func(w http.ResponseWriter, r *http.Request, n int) bool {
+		fmt.Fprint(w, `{"Errors":[{"Key":"ErrorSettings.InvalidProduct","Value":"The product is not valid.","Type":1}]}`)
+		return true
+	}
+	w := s.downloader(ArchX64, fastRetries)
+	_, err := w.GetDownloadURL(context.Background(), "2618")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || len(apiErr.Errors) != 1 || apiErr.Errors[0].Key != "ErrorSettings.InvalidProduct" {
+		t.Fatalf("got error %v, want the connector error", err)
+	}
+	if got := s.requests["sku"]; got != 1 {
+		t.Errorf("made %d SKU requests, want 1", got)
+	}
+}
+
+func TestGetDownloadURLBlocked(t *testing.T) {
+	s := newConnectorServer(t)
+	s.skuFail = func(w http.ResponseWriter, r *http.Request, n int) bool {
+		fmt.Fprint(w, blockedResponse)
+		return true
+	}
+	w := s.downloader(ArchX64, fastRetries)
+	_, err := w.GetDownloadURL(context.Background(), "2618")
+	var apiErr *APIError
+	if !errors.Is(err, ErrBlocked) || !errors.As(err, &apiErr) {
+		t.Fatalf("got error %v, want ErrBlocked", err)
+	}
+	want := ConnectorError{Key: "ErrorSettings.SentinelReject", Value: "Sentinel marked this request as rejected.", Type: 9}
+	if len(apiErr.Errors) != 1 || apiErr.Errors[0] != want {
+		t.Errorf("got connector errors %+v, want %+v as sent", apiErr.Errors, want)
+	}
+}
+
+func TestGetDownloadURLArchUnavailable(t *testing.T) {
+	s := newConnectorServer(t)
+	w := s.downloader(ArchARM64, fastRetries)
+	_, err := w.GetDownloadURL(context.Background(), "2618")
+	if !errors.Is(err, ErrArchUnavailable) {
+		t.Fatalf("got error %v, want ErrArchUnavailable", err)
+	}
+	if got := s.requests["link"]; got != 1 {
+		t.Errorf("made %d download link requests, want 1", got)
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}
+	for n, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if got := p.backoff(n + 1); got != want {
+			t.Errorf("backoff(%d) = %s, want %s", n+1, got, want)
+		}
+	}
+
+	p.Jitter = 0.5
+	for range 100 {
+		if got := p.backoff(2); got < time.Second || got > 2*time.Second {
+			t.Fatalf("backoff(2) with jitter = %s, want between 1s and 2s", got)
+		}
+	}
+}
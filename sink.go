@@ -0,0 +1,328 @@
+package winfonts
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Sink is where an extractor stores the fonts it saves and its manifest, such
+// as a directory or an archive.
+type Sink interface {
+	// WriteFile stores the size bytes read from r under name, a
+	// slash-separated path relative to the root of the sink.
+	WriteFile(name string, r io.Reader, size int64) error
+	// Close completes the output. No file may be written after Close.
+	Close() error
+}
+
+// OutputFormat identifies one of the built-in sinks.
+type OutputFormat string
+
+const (
+	OutputDir    OutputFormat = "dir"
+	OutputZip    OutputFormat = "zip"
+	OutputTarGz  OutputFormat = "tar.gz"
+	OutputTarZst OutputFormat = "tar.zst"
+)
+
+// ParseOutputFormat converts a user supplied name such as "zip" or "tar.gz"
+// into an OutputFormat. "tgz" is accepted for "tar.gz".
+func ParseOutputFormat(s string) (OutputFormat, error) {
+	switch strings.ToLower(strings.TrimPrefix(strings.TrimSpace(s), ".")) {
+	case "dir", "directory":
+		return OutputDir, nil
+	case "zip":
+		return OutputZip, nil
+	case "tar.gz", "tgz":
+		return OutputTarGz, nil
+	case "tar.zst", "tzst":
+		return OutputTarZst, nil
+	}
+	return "", fmt.Errorf("unknown output format %q", s)
+}
+
+// OpenSink creates the sink of the given format at path: a directory for
+// OutputDir, or an archive file for the other formats. An archive path of "-"
+// writes the archive to standard output.
+func OpenSink(path string, format OutputFormat) (Sink, error) {
+	if format == OutputDir {
+		return NewDirSink(path)
+	}
+
+	var w io.WriteCloser = nopWriteCloser{os.Stdout}
+	if path != "-" {
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create archive: %w", err)
+		}
+		w = f
+	}
+	var s Sink
+	switch format {
+	case OutputZip:
+		s = NewZipSink(w)
+	case OutputTarGz:
+		s = NewTarGzSink(w)
+	case OutputTarZst:
+		var err error
+		s, err = NewTarZstSink(w)
+		if err != nil {
+			w.Close()
+			return nil, err
+		}
+	default:
+		w.Close()
+		return nil, fmt.Errorf("unknown output format %q", format)
+	}
+	return closingSink{s, w}, nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// closingSink closes the file an archive sink writes to after the sink.
+type closingSink struct {
+	Sink
+	w io.Closer
+}
+
+func (s closingSink) Close() error {
+	err := s.Sink.Close()
+	if cerr := s.w.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// NewDirSink returns a sink that stores files in dir, creating it if needed.
+func NewDirSink(dir string) (Sink, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create output directory: %w", err)
+	}
+	return dirSink{dir}, nil
+}
+
+type dirSink struct {
+	dir string
+}
+
+// WriteFile writes to a temporary file that is renamed into place once
+// complete, so an interrupted extraction leaves no truncated fonts behind.
+func (s dirSink) WriteFile(name string, r io.Reader, size int64) error {
+	location := filepath.Join(s.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(location), 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(location), ".winfonts-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), location)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
+
+func (s dirSink) Close() error {
+	return nil
+}
+
+// archiveModTime is the modification time of every archive entry. A fixed
+// time keeps the archives of unchanged fonts byte-for-byte identical; it is
+// the earliest time a zip file can record.
+var archiveModTime = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// archiveWriter is the format specific part of an archive sink.
+type archiveWriter interface {
+	writeEntry(name string, r io.Reader, size int64) error
+	Close() error
+}
+
+// archiveSink stages the files it is given and only writes them to the
+// archive when closed, sorted by name, so that the archive does not depend on
+// the order fonts were found in. Together with fixed timestamps, normalized
+// permissions and fixed compression levels, this makes the archives of the
+// same fonts identical.
+type archiveSink struct {
+	w     archiveWriter
+	dir   string
+	files map[string]string
+}
+
+func newArchiveSink(w archiveWriter) *archiveSink {
+	return &archiveSink{w: w, files: make(map[string]string)}
+}
+
+func (s *archiveSink) WriteFile(name string, r io.Reader, size int64) error {
+	if s.dir == "" {
+		dir, err := os.MkdirTemp("", "winfonts-archive-*")
+		if err != nil {
+			return fmt.Errorf("failed to create staging directory: %w", err)
+		}
+		s.dir = dir
+	}
+	f, err := os.CreateTemp(s.dir, "entry-*")
+	if err != nil {
+		return fmt.Errorf("failed to stage %s: %w", name, err)
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("failed to stage %s: %w", name, err)
+	}
+	if old, ok := s.files[name]; ok {
+		os.Remove(old)
+	}
+	s.files[name] = f.Name()
+	return nil
+}
+
+func (s *archiveSink) Close() error {
+	defer func() {
+		if s.dir != "" {
+			os.RemoveAll(s.dir)
+		}
+	}()
+	for _, name := range slices.Sorted(maps.Keys(s.files)) {
+		if err := s.writeEntry(name, s.files[name]); err != nil {
+			s.w.Close()
+			return err
+		}
+	}
+	return s.w.Close()
+}
+
+func (s *archiveSink) writeEntry(name, staged string) error {
+	f, err := os.Open(staged)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if err := s.w.writeEntry(name, f, info.Size()); err != nil {
+		return fmt.Errorf("failed to add %s to archive: %w", name, err)
+	}
+	return nil
+}
+
+// NewZipSink returns a sink that writes a zip archive to w. Entries are
+// written when the sink is closed; closing it does not close w.
+func NewZipSink(w io.Writer) Sink {
+	zw := zip.NewWriter(w)
+	zw.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(w, flate.BestCompression)
+	})
+	return newArchiveSink(zipWriter{zw})
+}
+
+type zipWriter struct {
+	zw *zip.Writer
+}
+
+func (w zipWriter) writeEntry(name string, r io.Reader, size int64) error {
+	hdr := &zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: archiveModTime,
+	}
+	hdr.SetMode(0644)
+	fw, err := w.zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, r)
+	return err
+}
+
+func (w zipWriter) Close() error {
+	return w.zw.Close()
+}
+
+// NewTarGzSink returns a sink that writes a gzip compressed tar archive to w.
+// Entries are written when the sink is closed; closing it does not close w.
+func NewTarGzSink(w io.Writer) Sink {
+	// The gzip header is left without a name or modification time.
+	gw, _ := gzip.NewWriterLevel(w, gzip.BestCompression)
+	return newArchiveSink(newTarWriter(gw))
+}
+
+// NewTarZstSink returns a sink that writes a zstd compressed tar archive to w.
+// Entries are written when the sink is closed; closing it does not close w.
+func NewTarZstSink(w io.Writer) (Sink, error) {
+	// A single encoder goroutine keeps the output independent of the
+	// number of CPUs.
+	zw, err := zstd.NewWriter(w,
+		zstd.WithEncoderLevel(zstd.SpeedBetterCompression),
+		zstd.WithEncoderConcurrency(1),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create zstd writer: %w", err)
+	}
+	return newArchiveSink(newTarWriter(zw)), nil
+}
+
+// tarWriter writes a tar archive through a compressor, which is closed along
+// with the archive.
+type tarWriter struct {
+	tw *tar.Writer
+	c  io.WriteCloser
+}
+
+func newTarWriter(c io.WriteCloser) tarWriter {
+	return tarWriter{tar.NewWriter(c), c}
+}
+
+func (w tarWriter) writeEntry(name string, r io.Reader, size int64) error {
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     0644,
+		ModTime:  archiveModTime,
+	}
+	if err := w.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := io.Copy(w.tw, r)
+	return err
+}
+
+func (w tarWriter) Close() error {
+	err := w.tw.Close()
+	if cerr := w.c.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,113 @@
+package winfonts
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testFont returns a minimal file that passes the TrueType magic check.
+func testFont(seed byte) []byte {
+	data := make([]byte, 64)
+	binary.BigEndian.PutUint32(data, 0x00010000)
+	for i := 12; i < len(data); i++ {
+		data[i] = seed + byte(i)
+	}
+	return data
+}
+
+var archiveSinks = []struct {
+	name string
+	new  func(*bytes.Buffer) (Sink, error)
+}{
+	{"zip", func(b *bytes.Buffer) (Sink, error) { return NewZipSink(b), nil }},
+	{"tar.gz", func(b *bytes.Buffer) (Sink, error) { return NewTarGzSink(b), nil }},
+	{"tar.zst", func(b *bytes.Buffer) (Sink, error) { return NewTarZstSink(b) }},
+}
+
+func TestArchiveSinkOrderIndependent(t *testing.T) {
+	files := []string{"b.ttf", "a.ttf", "fonts.json", "c.ttc"}
+	for _, tt := range archiveSinks {
+		t.Run(tt.name, func(t *testing.T) {
+			write := func(order []string) []byte {
+				var buf bytes.Buffer
+				s, err := tt.new(&buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, name := range order {
+					data := testFont(name[0])
+					if err := s.WriteFile(name, bytes.NewReader(data), int64(len(data))); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if err := s.Close(); err != nil {
+					t.Fatal(err)
+				}
+				return buf.Bytes()
+			}
+
+			first := write(files)
+			reversed := []string{files[3], files[2], files[1], files[0]}
+			if second := write(reversed); !bytes.Equal(first, second) {
+				t.Errorf("archives differ when files are written in another order")
+			}
+		})
+	}
+}
+
+func TestArchiveSinkReproducible(t *testing.T) {
+	src := t.TempDir()
+	fontsDir := filepath.Join(src, "Windows", "Fonts")
+	if err := os.MkdirAll(fontsDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for i, name := range []string{"arial.ttf", "times.ttf", "cour.ttf"} {
+		path := filepath.Join(fontsDir, name)
+		if err := os.WriteFile(path, testFont(byte(i)), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tt := range archiveSinks {
+		t.Run(tt.name, func(t *testing.T) {
+			run := func() []byte {
+				var buf bytes.Buffer
+				s, err := tt.new(&buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				source, err := NewDirSource(src)
+				if err != nil {
+					t.Fatal(err)
+				}
+				e := NewSourceExtractor("", []Source{source}, WithSink(s))
+				if err := e.Run(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+				if got := len(e.Fonts()); got != 3 {
+					t.Fatalf("extracted %d fonts, want 3", got)
+				}
+				if err := s.Close(); err != nil {
+					t.Fatal(err)
+				}
+				return buf.Bytes()
+			}
+
+			first := run()
+			// Touch the fonts so that nothing taken from the
+			// filesystem can make the runs agree by chance.
+			later := time.Now().Add(time.Hour)
+			for _, name := range []string{"arial.ttf", "times.ttf", "cour.ttf"} {
+				os.Chtimes(filepath.Join(fontsDir, name), later, later)
+			}
+			if second := run(); !bytes.Equal(first, second) {
+				t.Errorf("two extractions of the same fonts produced different archives")
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package winfonts
+
+import (
+	"context"
+	"io"
+	"iter"
+)
+
+// FontCandidate is a file that a Source found and that may be a font.
+// Candidates are listed before their content is read, so a candidate whose
+// content turns out not to be a font is skipped when extracting. The WIM
+// fields are empty for fonts that do not come from a WIM image.
+type FontCandidate struct {
+	// Name is the file name, which the font is saved under.
+	Name string `json:"name"`
+	// Path is the slash-separated path of the file within its source.
+	Path       string     `json:"path"`
+	Wim        string     `json:"wim,omitempty"`
+	ImageIndex int        `json:"image_index,omitempty"`
+	ImageName  string     `json:"image_name,omitempty"`
+	Format     FontFormat `json:"format"`
+	// Size is the size of the file as listed by the source, which may
+	// leave it 0 when it is not known. The extractor does not rely on it,
+	// but measures the content it reads.
+	Size int64 `json:"size"`
+	// SHA1 is the hash the WIM stores for the file's content stream. When
+	// set, candidates with the same SHA1 are only read once.
+	SHA1 string `json:"sha1,omitempty"`
+
+	// Open returns the content of the file.
+	Open func() (io.ReadCloser, error) `json:"-"`
+}
+
+// SearchOptions tells a Source where to look for fonts.
+type SearchOptions struct {
+	// Roots are the directories to search, relative to the root of each
+	// filesystem or image of the source.
+	Roots []string
+	// FullWalk asks for every directory to be searched instead of Roots.
+	FullWalk bool
+	// SkipDirs are directories of the host filesystem, such as the output
+	// directory, that sources reading from it must not descend into.
+	SkipDirs []string
+}
+
+// Source is a place fonts are extracted from, such as an ISO image, a WIM
+// file or a directory.
+//
+// Candidates yields the files of the source that may be fonts. Sources do not
+// need to filter them: the extractor only keeps the candidates whose name has
+// the extension of a configured format, and sets their Format. Iteration
+// stops at the first error, which is yielded along with a zero FontCandidate.
+type Source interface {
+	Candidates(ctx context.Context, opts SearchOptions) iter.Seq2[FontCandidate, error]
+}
+
+// NewSourceExtractor returns an extractor that reads fonts from each of the
+// sources in turn and saves them into output. Fonts found in several sources
+// are deduplicated like fonts found in several images of one source.
+func NewSourceExtractor(output string, sources []Source, opts ...ExtractorOption) *FontExtractor {
+	return newFontExtractor(sources, output, opts)
+}
+
+// Candidates lists the fonts of the configured formats in every source of the
+// extractor, without writing anything to the output directory. Iteration stops
+// at the first error, which is yielded along with a zero FontCandidate.
+func (e *FontExtractor) Candidates(ctx context.Context) iter.Seq2[FontCandidate, error] {
+	return func(yield func(FontCandidate, error) bool) {
+		search := SearchOptions{Roots: e.roots, FullWalk: e.walkAll}
+		if e.output != "" {
+			search.SkipDirs = []string{e.output}
+		}
+		for _, src := range e.sources {
+			for c, err := range src.Candidates(ctx, search) {
+				if err != nil {
+					yield(FontCandidate{}, err)
+					return
+				}
+				format, ok := e.fontFormat(c.Name)
+				if !ok {
+					continue
+				}
+				c.Format = format
+				if !yield(c, nil) {
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package winfonts
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// searchSource is a Source that records the options it is searched with and
+// then yields err, if set.
+type searchSource struct {
+	search *SearchOptions
+	err    error
+}
+
+func (s searchSource) Candidates(ctx context.Context, opts SearchOptions) iter.Seq2[FontCandidate, error] {
+	return func(yield func(FontCandidate, error) bool) {
+		*s.search = opts
+		if s.err != nil {
+			yield(FontCandidate{}, s.err)
+		}
+	}
+}
+
+// candidateNames returns the names and formats of the candidates of e, as
+// "name:format".
+func candidateNames(t *testing.T, e *FontExtractor) []string {
+	t.Helper()
+	var got []string
+	for c, err := range e.Candidates(context.Background()) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, c.Name+":"+string(c.Format))
+	}
+	return got
+}
+
+func TestCandidatesFormats(t *testing.T) {
+	first := memSource{
+		memCandidate("", 0, "b/times.ttf", nil),
+		memCandidate("", 0, "b/readme.txt", nil),
+		memCandidate("", 0, "b/ARIAL.TTF", nil),
+		memCandidate("", 0, "b/sserife.fon", nil),
+		memCandidate("", 0, "b/cga.FNT", nil),
+		memCandidate("", 0, "b/msgothic.ttc", nil),
+	}
+	second := memSource{
+		memCandidate("", 0, "a/source.otf", nil),
+		memCandidate("", 0, "a/noext", nil),
+		memCandidate("", 0, "a/arial.ttf", nil),
+	}
+	for _, tt := range []struct {
+		name    string
+		formats []FontFormat
+		want    []string
+	}{
+		{"default", nil, []string{
+			"times.ttf:ttf", "ARIAL.TTF:ttf", "sserife.fon:fon", "cga.FNT:fon", "msgothic.ttc:ttc",
+			"source.otf:otf", "arial.ttf:ttf",
+		}},
+		{"ttf", []FontFormat{FormatTrueType}, []string{"times.ttf:ttf", "ARIAL.TTF:ttf", "arial.ttf:ttf"}},
+		{"fon and otf", []FontFormat{FormatBitmap, FormatOpenType}, []string{
+			"sserife.fon:fon", "cga.FNT:fon", "source.otf:otf",
+		}},
+		{"none", []FontFormat{}, nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var opts []ExtractorOption
+			if tt.formats != nil {
+				opts = append(opts, WithFormats(tt.formats...))
+			}
+			e := NewSourceExtractor("", []Source{first, second}, opts...)
+			if got := candidateNames(t, e); !slices.Equal(got, tt.want) {
+				t.Errorf("got candidates %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCandidatesSearchOptions(t *testing.T) {
+	var search SearchOptions
+	e := NewSourceExtractor("out", []Source{searchSource{search: &search}},
+		WithSearchRoots("Fonts", `Program Files\Fonts`), WithFullWalk(true))
+	candidateNames(t, e)
+	want := SearchOptions{Roots: []string{"Fonts", `Program Files\Fonts`}, FullWalk: true, SkipDirs: []string{"out"}}
+	if !slices.Equal(search.Roots, want.Roots) || search.FullWalk != want.FullWalk || !slices.Equal(search.SkipDirs, want.SkipDirs) {
+		t.Errorf("source searched with %+v, want %+v", search, want)
+	}
+
+	e = NewSourceExtractor("", []Source{searchSource{search: &search}})
+	candidateNames(t, e)
+	if !slices.Equal(search.Roots, DefaultSearchRoots) || search.FullWalk || search.SkipDirs != nil {
+		t.Errorf("source searched with %+v, want the default roots and nothing skipped", search)
+	}
+}
+
+func TestCandidatesStopAtError(t *testing.T) {
+	errSource := errors.New("source failed")
+	var search SearchOptions
+	e := NewSourceExtractor("", []Source{
+		memSource{memCandidate("", 0, "arial.ttf", nil)},
+		searchSource{search: &search, err: errSource},
+		memSource{memCandidate("", 0, "times.ttf", nil)},
+	})
+	var got []string
+	var gotErr error
+	for c, err := range e.Candidates(context.Background()) {
+		if err != nil {
+			gotErr = err
+			continue
+		}
+		got = append(got, c.Name)
+	}
+	if !errors.Is(gotErr, errSource) || !slices.Equal(got, []string{"arial.ttf"}) {
+		t.Errorf("got candidates %q and error %v, want arial.ttf then %v", got, gotErr, errSource)
+	}
+}
+
+// unsizedSource is a Source that, like many third-party ones, does not know
+// the size of its files.
+type unsizedSource map[string][]byte
+
+func (s unsizedSource) Candidates(ctx context.Context, opts SearchOptions) iter.Seq2[FontCandidate, error] {
+	return func(yield func(FontCandidate, error) bool) {
+		for _, name := range slices.Sorted(maps.Keys(s)) {
+			c := memCandidate("", 0, "fonts/"+name, s[name])
+			c.Size = 0
+			if !yield(c, nil) {
+				return
+			}
+		}
+	}
+}
+
+func TestRunCustomSource(t *testing.T) {
+	files := unsizedSource{
+		"arial.ttf":   testFont(1),
+		"cga.fnt":     rawFnt(200, 300),
+		"sserife.fon": neFont(5000, 6000, "NE"),
+		"fake.fnt":    rawFnt(400, 300),
+		"empty.ttf":   nil,
+	}
+	e, output := runExtractor(t, files)
+	var got []string
+	for _, font := range e.Fonts() {
+		got = append(got, font.Name)
+		if font.Size != int64(len(files[font.Name])) {
+			t.Errorf("%s has size %d, want %d", font.Name, font.Size, len(files[font.Name]))
+		}
+		checkOutputFile(t, output, font.Name, files[font.Name])
+	}
+	if want := []string{"arial.ttf", "cga.fnt", "sserife.fon"}; !slices.Equal(got, want) {
+		t.Errorf("extracted %q, want %q", got, want)
+	}
+	if _, err := os.Stat(filepath.Join(output, ManifestName)); err != nil {
+		t.Errorf("no manifest written: %v", err)
+	}
+}